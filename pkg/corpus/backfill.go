@@ -0,0 +1,61 @@
+package corpus
+
+import (
+	"context"
+	"database/sql"
+	"math"
+
+	"github.com/skalt/pg_sql_tests/pkg/languages"
+)
+
+// backfill pages through the pgsql statements that have no rows in the table,
+// calling fn with each of them inside one transaction per page.
+func backfill(ctx context.Context, db *sql.DB, table string, fn func(*sql.Tx, *Statement) error) error {
+	const pageSize = DefaultPageSize
+	query := `
+		SELECT stmt.id, stmt.text
+		FROM statements AS stmt
+		JOIN statement_languages AS lang ON lang.statement_id = stmt.id AND lang.language_id = ?
+		WHERE stmt.id >= ?
+		  AND NOT EXISTS (SELECT 1 FROM ` + table + ` AS t WHERE t.statement_id = stmt.id)
+		ORDER BY stmt.id
+		LIMIT ?`
+	fromId := int64(math.MinInt64)
+	for {
+		var page []*Statement
+		rows, err := db.QueryContext(ctx, query, languages.Pgsql, fromId, pageSize)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var statement Statement
+			if err := rows.Scan(&statement.Id, &statement.Text); err != nil {
+				rows.Close()
+				return err
+			}
+			page = append(page, &statement)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		txn, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		for _, statement := range page {
+			if err := fn(txn, statement); err != nil {
+				txn.Rollback()
+				return err
+			}
+		}
+		if err := txn.Commit(); err != nil {
+			return err
+		}
+		if len(page) < pageSize || page[len(page)-1].Id == math.MaxInt64 {
+			return nil
+		}
+		fromId = page[len(page)-1].Id + 1
+	}
+}
@@ -0,0 +1,402 @@
+package corpus
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v2"
+	"github.com/skalt/pg_sql_tests/pkg/languages"
+)
+
+// the invariants CheckCorpus verifies
+const (
+	// a statement's id is the HashId of its text
+	CheckStatementId = "statement-id"
+	// a document_statements span is as long as the statement's text. Documents'
+	// texts aren't stored, so the span's bytes can't be compared.
+	CheckSpan = "span"
+	// a document's spans don't overlap
+	CheckOverlap = "overlap"
+	// every statement has at least one language
+	CheckLanguage = "language"
+	// every foreign key refers to an existing row
+	CheckForeignKey = "foreign-key"
+)
+
+// a Problem is a violated invariant found by CheckCorpus.
+type Problem struct {
+	Check string
+	Table string
+	// identifies the offending row, e.g. a hexadecimal statement id
+	Key    string
+	Detail string
+	// whether CheckCorpus fixed the problem
+	Repaired bool
+}
+
+// tables whose statement_id column refers to statements(id)
+var statementReferences = []string{
+	"statement_languages", "statement_fingerprints", "statement_tokens", "statement_nodes",
+	"statement_options", "statement_versions",
+	"document_statements", "predictions", "prediction_conflicts", "derived_statements",
+}
+
+type checker struct {
+	ctx    context.Context
+	txn    *sql.Tx
+	repair bool
+	report func(*Problem) error
+}
+
+// CheckCorpus verifies the corpus' invariants, calling fn with each problem it
+// finds. If repair is set, it also fixes what it can in a single transaction:
+//   - statements whose ids don't match their texts are re-keyed everywhere,
+//     unless a statement with a different text already has the correct id
+//   - spans are recomputed from their start and the statement's text
+//   - statements without a language are tagged pgsql if pg_query can parse
+//     them, psql if they're meta-commands, and "other" otherwise
+//   - predictions by unregistered oracles get a placeholder oracle, documents
+//     referred to but missing are added, urls with unknown licenses lose their
+//     license, and other rows referring to missing rows are deleted
+//
+// Overlapping spans are only reported.
+func CheckCorpus(ctx context.Context, db *sql.DB, repair bool, fn func(*Problem) error) error {
+	txn, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+	c := checker{ctx, txn, repair, fn}
+	checks := []func() error{c.statementIds, c.spans, c.overlaps, c.languages, c.foreignKeys}
+	for _, check := range checks {
+		if err := check(); err != nil {
+			return err
+		}
+	}
+	if repair {
+		return txn.Commit()
+	}
+	return nil
+}
+
+func hexId(id int64) string {
+	return fmt.Sprintf("%x", uint64(id))
+}
+
+// query collects every row before calling fn, so that fn can modify the tables
+// being read.
+func (c *checker) query(query string, scan func(*sql.Rows) (func() error, error)) error {
+	rows, err := c.txn.QueryContext(c.ctx, query)
+	if err != nil {
+		return err
+	}
+	var actions []func() error
+	for rows.Next() {
+		action, err := scan(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if action != nil {
+			actions = append(actions, action)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, action := range actions {
+		if err := action(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *checker) exec(query string, args ...interface{}) error {
+	_, err := c.txn.ExecContext(c.ctx, query, args...)
+	return err
+}
+
+func (c *checker) statementIds() error {
+	return c.query("SELECT id, text FROM statements", func(rows *sql.Rows) (func() error, error) {
+		var id int64
+		var text string
+		if err := rows.Scan(&id, &text); err != nil {
+			return nil, err
+		}
+		expected := HashId(text)
+		if id == expected {
+			return nil, nil
+		}
+		return func() error {
+			problem := Problem{
+				Check:  CheckStatementId,
+				Table:  "statements",
+				Key:    hexId(id),
+				Detail: fmt.Sprintf("the text hashes to %s", hexId(expected)),
+			}
+			var existing string
+			err := c.txn.QueryRowContext(c.ctx, "SELECT text FROM statements WHERE id = ?", expected).Scan(&existing)
+			if err == nil && existing != text {
+				// a hash collision; re-keying would attach this statement's rows to
+				// the other one
+				problem.Detail += ", which belongs to a statement with a different text"
+				return c.report(&problem)
+			} else if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+			if c.repair {
+				if err := c.rekeyStatement(id, expected); err != nil {
+					return err
+				}
+				problem.Repaired = true
+			}
+			return c.report(&problem)
+		}, nil
+	})
+}
+
+// rekeyStatement moves every reference to the statement to its correct id,
+// dropping rows that would duplicate ones already there. The statement at the
+// correct id, if any, must have the same text.
+func (c *checker) rekeyStatement(from int64, to int64) error {
+	err := c.exec("INSERT OR IGNORE INTO statements (id, text) SELECT ?, text FROM statements WHERE id = ?", to, from)
+	if err != nil {
+		return err
+	}
+	for _, table := range statementReferences {
+		query := "UPDATE OR IGNORE " + table + " SET statement_id = ? WHERE statement_id = ?"
+		if err := c.exec(query, to, from); err != nil {
+			return err
+		}
+		if err := c.exec("DELETE FROM "+table+" WHERE statement_id = ?", from); err != nil {
+			return err
+		}
+	}
+	for _, table := range []string{"predictions", "prediction_conflicts"} {
+		if err := c.exec("UPDATE "+table+" SET inferred_from = ? WHERE inferred_from = ?", to, from); err != nil {
+			return err
+		}
+	}
+	err = c.exec("UPDATE OR IGNORE derived_statements SET parent_id = ? WHERE parent_id = ?", to, from)
+	if err != nil {
+		return err
+	}
+	if err := c.exec("DELETE FROM derived_statements WHERE parent_id = ?", from); err != nil {
+		return err
+	}
+	return c.exec("DELETE FROM statements WHERE id = ?", from)
+}
+
+func (c *checker) spans() error {
+	query := `
+		SELECT src.rowid, src.document_id, src.statement_id, src.start_line, src.end_line,
+		       src.start_offset, src.end_offset, stmt.text
+		FROM document_statements AS src
+		JOIN statements AS stmt ON stmt.id = src.statement_id`
+	return c.query(query, func(rows *sql.Rows) (func() error, error) {
+		var rowid, documentId, statementId int64
+		var startLine, endLine, startOffset, endOffset sql.NullInt64
+		var text string
+		err := rows.Scan(&rowid, &documentId, &statementId, &startLine, &endLine, &startOffset, &endOffset, &text)
+		if err != nil {
+			return nil, err
+		}
+		if !startLine.Valid || !startOffset.Valid {
+			return func() error {
+				return c.report(&Problem{
+					Check:  CheckSpan,
+					Table:  "document_statements",
+					Key:    hexId(documentId) + " " + hexId(statementId),
+					Detail: "the span has no start",
+				})
+			}, nil
+		}
+		wantEndLine := startLine.Int64 + int64(strings.Count(text, "\n"))
+		wantEndOffset := startOffset.Int64 + int64(len(text))
+		if endLine.Int64 == wantEndLine && endOffset.Int64 == wantEndOffset && endLine.Valid && endOffset.Valid {
+			return nil, nil
+		}
+		return func() error {
+			problem := Problem{
+				Check: CheckSpan,
+				Table: "document_statements",
+				Key:   fmt.Sprintf("%s %s@%d", hexId(documentId), hexId(statementId), startOffset.Int64),
+				Detail: fmt.Sprintf(
+					"lines %d-%d and bytes %d-%d don't fit the statement's text, which ends at line %d, byte %d",
+					startLine.Int64, endLine.Int64, startOffset.Int64, endOffset.Int64, wantEndLine, wantEndOffset,
+				),
+			}
+			if c.repair {
+				err := c.exec(
+					"UPDATE document_statements SET end_line = ?, end_offset = ? WHERE rowid = ?",
+					wantEndLine, wantEndOffset, rowid,
+				)
+				if err != nil {
+					return err
+				}
+				problem.Repaired = true
+			}
+			return c.report(&problem)
+		}, nil
+	})
+}
+
+func (c *checker) overlaps() error {
+	query := `
+		SELECT document_id, statement_id, start_offset, previous_id, previous_end FROM (
+		  SELECT
+		      document_id, statement_id, start_offset
+		    , lag(statement_id) OVER by_offset AS previous_id
+		    , lag(end_offset) OVER by_offset AS previous_end
+		  FROM document_statements
+		  WINDOW by_offset AS (PARTITION BY document_id ORDER BY start_offset)
+		)
+		WHERE start_offset < previous_end`
+	return c.query(query, func(rows *sql.Rows) (func() error, error) {
+		var documentId, statementId, startOffset, previousId, previousEnd int64
+		if err := rows.Scan(&documentId, &statementId, &startOffset, &previousId, &previousEnd); err != nil {
+			return nil, err
+		}
+		return func() error {
+			return c.report(&Problem{
+				Check: CheckOverlap,
+				Table: "document_statements",
+				Key:   fmt.Sprintf("%s %s@%d", hexId(documentId), hexId(statementId), startOffset),
+				Detail: fmt.Sprintf(
+					"starts before the end of statement %s at byte %d", hexId(previousId), previousEnd,
+				),
+			})
+		}, nil
+	})
+}
+
+// guessLanguage tags statements the way the splitter would have.
+func guessLanguage(text string) int64 {
+	if _, err := pg_query.Parse(text); err == nil {
+		return languages.Pgsql
+	}
+	if strings.HasPrefix(strings.TrimSpace(text), `\`) {
+		return languages.Psql
+	}
+	return languages.Other
+}
+
+func (c *checker) languages() error {
+	query := `
+		SELECT stmt.id, stmt.text FROM statements AS stmt
+		WHERE NOT EXISTS (SELECT 1 FROM statement_languages AS lang WHERE lang.statement_id = stmt.id)`
+	return c.query(query, func(rows *sql.Rows) (func() error, error) {
+		var id int64
+		var text string
+		if err := rows.Scan(&id, &text); err != nil {
+			return nil, err
+		}
+		return func() error {
+			problem := Problem{
+				Check:  CheckLanguage,
+				Table:  "statements",
+				Key:    hexId(id),
+				Detail: "the statement has no language",
+			}
+			if c.repair {
+				language := guessLanguage(text)
+				err := c.exec("INSERT INTO statement_languages (statement_id, language_id) VALUES (?, ?)", id, language)
+				if err != nil {
+					return err
+				}
+				problem.Detail += fmt.Sprintf("; tagged it as language %d", language)
+				problem.Repaired = true
+			}
+			return c.report(&problem)
+		}, nil
+	})
+}
+
+type foreignKey struct {
+	from, to string // columns
+}
+
+func (c *checker) foreignKeyColumns(table string) (map[int64]foreignKey, error) {
+	rows, err := c.txn.QueryContext(c.ctx, "SELECT id, \"from\", \"to\" FROM pragma_foreign_key_list(?)", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := map[int64]foreignKey{}
+	for rows.Next() {
+		var id int64
+		var key foreignKey
+		if err := rows.Scan(&id, &key.from, &key.to); err != nil {
+			return nil, err
+		}
+		result[id] = key
+	}
+	return result, rows.Err()
+}
+
+func (c *checker) foreignKeys() error {
+	keys := map[string]map[int64]foreignKey{}
+	return c.query("PRAGMA foreign_key_check", func(rows *sql.Rows) (func() error, error) {
+		var table, parent string
+		var rowid, fkid int64
+		if err := rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			return nil, err
+		}
+		return func() error {
+			if _, ok := keys[table]; !ok {
+				columns, err := c.foreignKeyColumns(table)
+				if err != nil {
+					return err
+				}
+				keys[table] = columns
+			}
+			key := keys[table][fkid]
+			var value interface{}
+			query := fmt.Sprintf(`SELECT "%s" FROM "%s" WHERE rowid = ?`, key.from, table)
+			if err := c.txn.QueryRowContext(c.ctx, query, rowid).Scan(&value); err == sql.ErrNoRows {
+				return nil // already deleted while repairing an earlier key
+			} else if err != nil {
+				return err
+			}
+			display := fmt.Sprint(value)
+			if id, ok := value.(int64); ok && parent != "languages" {
+				display = hexId(id)
+			}
+			problem := Problem{
+				Check:  CheckForeignKey,
+				Table:  table,
+				Key:    fmt.Sprintf("%s=%s", key.from, display),
+				Detail: fmt.Sprintf("%s.%s has no row with %s=%s", parent, key.to, key.to, display),
+			}
+			if c.repair {
+				var err error
+				switch {
+				case parent == "oracles":
+					problem.Detail += "; registered a placeholder oracle"
+					err = c.exec(
+						"INSERT OR IGNORE INTO oracles (id, name) VALUES (?, ?)",
+						value, "unregistered oracle "+display,
+					)
+				case parent == "documents":
+					problem.Detail += "; added the document"
+					err = c.exec("INSERT OR IGNORE INTO documents (id) VALUES (?)", value)
+				case table == "urls" && parent == "licenses":
+					problem.Detail += "; cleared the license"
+					err = c.exec("UPDATE urls SET license_id = NULL WHERE rowid = ?", rowid)
+				default:
+					problem.Detail += "; deleted the row"
+					err = c.exec(fmt.Sprintf(`DELETE FROM "%s" WHERE rowid = ?`, table), rowid)
+				}
+				if err != nil {
+					return err
+				}
+				problem.Repaired = true
+			}
+			return c.report(&problem)
+		}, nil
+	})
+}
@@ -1,36 +1,36 @@
 package corpus
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
+	"os"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 var MAJOR int = 0
-var MINOR int = 0
+var MINOR int = 8
 
-func ConnectToExisting(datasource string) (db *sql.DB, err error) {
-	db, err = sql.Open("sqlite3", datasource)
-	if err != nil {
+// OpenExisting opens the corpus database at path without checking its schema
+// version, e.g. to migrate it. It fails if there's no file at path rather than
+// letting sqlite create an empty database.
+func OpenExisting(path string) (*sql.DB, error) {
+	if _, err := os.Stat(path); err != nil {
 		return nil, err
 	}
-	rows, err := db.Query("select major, minor from schema_version order by major desc, minor desc limit 1")
+	return sql.Open("sqlite3", path)
+}
+
+// ConnectToExisting opens the corpus database at path, checking that its
+// schema is compatible with this program. See CheckCompatibility.
+func ConnectToExisting(path string) (db *sql.DB, err error) {
+	db, err = OpenExisting(path)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var major int
-		var minor int
-		if err := rows.Scan(&major, &minor); err != nil {
-			return db, err
-		}
-		// TODO: accept same major version
-		if major != MAJOR || minor != MINOR { // HACK: expects exact version
-			return db, fmt.Errorf("expected version 0.0, got %d.%d", major, minor)
-		}
+	major, minor, err := SchemaVersion(context.Background(), db)
+	if err != nil {
+		return db, err
 	}
-
-	return db, nil
+	return db, CheckCompatibility(major, minor)
 }
@@ -0,0 +1,126 @@
+package corpus
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"sort"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v2"
+)
+
+// GrammarNodeTypes lists every type of node a pg_query parse tree can
+// contain, i.e. the members of the Node oneof, e.g. "SelectStmt".
+func GrammarNodeTypes() []string {
+	var nodes []string
+	fields := (&pg_query.Node{}).ProtoReflect().Descriptor().Oneofs().ByName("node").Fields()
+	for i := 0; i < fields.Len(); i++ {
+		nodes = append(nodes, string(fields.Get(i).Message().Name()))
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// GrammarKeywords lists the tokens of pg_query's keyword list, named the way
+// statement_tokens names them, e.g. "SELECT" or "IDENTITY_P". pg_query only
+// exposes the keyword list through its scanner, so a token is a keyword if
+// scanning its name alone yields that token as a keyword. That leaves out the
+// lookahead tokens, e.g. "NOT_LA", which the grammar never sees as words.
+func GrammarKeywords() []string {
+	var keywords []string
+	values := pg_query.Token(0).Descriptor().Values()
+	for i := 0; i < values.Len(); i++ {
+		name := string(values.Get(i).Name())
+		result, err := pg_query.Scan(strings.ToLower(strings.TrimSuffix(name, "_P")))
+		if err != nil || len(result.Tokens) != 1 {
+			continue
+		}
+		token := result.Tokens[0]
+		if token.Token.String() == name && token.KeywordKind != pg_query.KeywordKind_NO_KEYWORD {
+			keywords = append(keywords, name)
+		}
+	}
+	sort.Strings(keywords)
+	return keywords
+}
+
+// the kinds of grammar constructs GetConstructCoverage reports on
+const (
+	ConstructNode    = "node"
+	ConstructKeyword = "keyword"
+)
+
+// a ConstructUse says that a version's oracles graded statements using a node
+// type or keyword, and whether the version accepts any of them.
+type ConstructUse struct {
+	Version   string
+	Kind      string // ConstructNode or ConstructKeyword
+	Construct string
+	Accepted  bool
+}
+
+//go:embed sql/get_construct_coverage.sql
+var getConstructCoverageQuery string
+
+// GetConstructCoverage lists the node types and keywords in the language's
+// statements by each version whose oracles graded them. As with `corpus
+// versions`, a version accepts a statement if all of its oracles' graded
+// predictions say the statement is valid. Node types and keywords come from
+// statement_nodes and statement_tokens, so statements that BackfillNodes and
+// BackfillTokens haven't indexed are left out, as are statements that don't
+// match constructs.
+func GetConstructCoverage(
+	ctx context.Context,
+	db *sql.DB,
+	languageId int64,
+	constructs ConstructFilter,
+) ([]*ConstructUse, error) {
+	oracles, err := GetOracles(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	// temp tables only exist on the connection that made them
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	steps := []string{
+		"DROP TABLE IF EXISTS temp.coverage_versions",
+		"CREATE TEMP TABLE coverage_versions (oracle_id INTEGER PRIMARY KEY, version TEXT)",
+	}
+	for _, step := range steps {
+		if _, err := conn.ExecContext(ctx, step); err != nil {
+			return nil, err
+		}
+	}
+	defer conn.ExecContext(ctx, "DROP TABLE IF EXISTS temp.coverage_versions")
+	for _, oracle := range oracles {
+		version := oracle.Version()
+		if version == "" {
+			continue
+		}
+		_, err := conn.ExecContext(ctx,
+			"INSERT INTO temp.coverage_versions (oracle_id, version) VALUES (?, ?)",
+			oracle.Id, version)
+		if err != nil {
+			return nil, err
+		}
+	}
+	params := append([]interface{}{sql.Named("language_id", languageId)}, constructs.params()...)
+	rows, err := conn.QueryContext(ctx, getConstructCoverageQuery, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var uses []*ConstructUse
+	for rows.Next() {
+		var use ConstructUse
+		if err := rows.Scan(&use.Version, &use.Kind, &use.Construct, &use.Accepted); err != nil {
+			return nil, err
+		}
+		uses = append(uses, &use)
+	}
+	return uses, rows.Err()
+}
@@ -0,0 +1,83 @@
+package corpus
+
+import (
+	"context"
+	"database/sql"
+
+	pg_query "github.com/pganalyze/pg_query_go/v2"
+	"github.com/skalt/pg_sql_tests/pkg/languages"
+)
+
+// how a derived statement was made from its parent
+const (
+	// shrunk while keeping an oracle's verdict
+	DerivationMinimized = "minimized"
+	// tokens dropped, duplicated, swapped, or replaced by a fuzzer
+	DerivationMutated = "mutated"
+)
+
+// a DerivedStatement is a statement made from another statement in the corpus.
+type DerivedStatement struct {
+	ParentId   int64
+	Text       string
+	Derivation string
+	// the oracle whose verdict the derivation kept, if any
+	OracleId *int64
+}
+
+// AddDerivedStatement adds the statement with the languages of its parent,
+// fingerprinting it if it's pgsql, and links it to its parent. It returns the
+// statement's id.
+func AddDerivedStatement(ctx context.Context, db *sql.DB, derived *DerivedStatement) (id int64, err error) {
+	id = HashId(derived.Text)
+	txn, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return id, err
+	}
+	defer txn.Rollback()
+	exec := func(query string, args ...interface{}) {
+		if err == nil {
+			_, err = txn.ExecContext(ctx, query, args...)
+		}
+	}
+	exec("INSERT INTO statements (id, text) VALUES (?, ?) ON CONFLICT(id) DO NOTHING", id, derived.Text)
+	exec(`
+		INSERT INTO statement_languages (statement_id, language_id)
+		SELECT ?, language_id FROM statement_languages WHERE statement_id = ?
+		ON CONFLICT DO NOTHING`,
+		id, derived.ParentId,
+	)
+	var isPgsql bool
+	if err == nil {
+		err = txn.QueryRowContext(ctx,
+			"SELECT count(*) > 0 FROM statement_languages WHERE statement_id = ? AND language_id = ?",
+			id, languages.Pgsql,
+		).Scan(&isPgsql)
+	}
+	if isPgsql {
+		if fingerprint, e := pg_query.FingerprintToUInt64(derived.Text); e == nil {
+			exec(
+				"INSERT INTO statement_fingerprints (statement_id, fingerprint) VALUES (?, ?) ON CONFLICT DO NOTHING",
+				id, int64(fingerprint),
+			)
+		}
+	}
+	// the primary key doesn't stop duplicates with a null oracle_id
+	exec(`
+		INSERT INTO derived_statements (statement_id, parent_id, derivation, oracle_id)
+		SELECT :id, :parent_id, :derivation, :oracle_id
+		WHERE NOT EXISTS (
+		  SELECT 1 FROM derived_statements
+		  WHERE statement_id = :id AND parent_id = :parent_id
+		    AND derivation = :derivation AND oracle_id IS :oracle_id
+		)`,
+		sql.Named("id", id),
+		sql.Named("parent_id", derived.ParentId),
+		sql.Named("derivation", derived.Derivation),
+		sql.Named("oracle_id", derived.OracleId),
+	)
+	if err != nil {
+		return id, err
+	}
+	return id, txn.Commit()
+}
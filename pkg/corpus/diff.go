@@ -0,0 +1,243 @@
+package corpus
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"sort"
+)
+
+// an OraclePair matches an oracle in an older corpus with one in a newer
+// corpus: either the same oracle, or the only oracle with the same name in
+// each corpus, e.g. after an image bump changed its metadata.
+type OraclePair struct {
+	Old *OracleInfo
+	New *OracleInfo
+	// how many statements both oracles predicted in the same language
+	Compared int64
+	Changes  []*ValidityChange
+}
+
+// a ValidityChange counts the predictions of a pair of oracles whose validity
+// changed the same way, e.g. from "valid" to "invalid".
+type ValidityChange struct {
+	Was      string // "valid", "invalid", or "unknown"
+	Now      string
+	Count    int64
+	Examples []*ChangedPrediction
+}
+
+// Flipped is true if the oracles reached opposite verdicts.
+func (change *ValidityChange) Flipped() bool {
+	return change.Was != "unknown" && change.Now != "unknown"
+}
+
+type ChangedPrediction struct {
+	StatementId int64
+	Text        string
+	OldError    string
+	NewError    string
+}
+
+// a CorpusDiff summarizes what changed between an older and a newer corpus.
+type CorpusDiff struct {
+	AddedStatements   int64
+	RemovedStatements int64
+	AddedExamples     []*Statement
+	RemovedExamples   []*Statement
+	AddedOracles      []*OracleInfo
+	RemovedOracles    []*OracleInfo
+	Oracles           []*OraclePair
+}
+
+// Flips counts predictions that went from valid to invalid or vice versa.
+func (diff *CorpusDiff) Flips() (flips int64) {
+	for _, pair := range diff.Oracles {
+		for _, change := range pair.Changes {
+			if change.Flipped() {
+				flips += change.Count
+			}
+		}
+	}
+	return flips
+}
+
+//go:embed sql/diff_predictions.sql
+var diffPredictionsQuery string
+
+//go:embed sql/diff_prediction_examples.sql
+var diffPredictionExamplesQuery string
+
+// pairOracles matches oracles by id, then pairs the leftovers by name when
+// each name is unique among them.
+func pairOracles(old, new map[int64]*OracleInfo) (pairs []*OraclePair, added, removed []*OracleInfo) {
+	byName := func(oracles map[int64]*OracleInfo, other map[int64]*OracleInfo) map[string][]*OracleInfo {
+		result := map[string][]*OracleInfo{}
+		for id, oracle := range oracles {
+			if _, ok := other[id]; !ok {
+				result[oracle.Name] = append(result[oracle.Name], oracle)
+			}
+		}
+		return result
+	}
+	oldNames, newNames := byName(old, new), byName(new, old)
+	for id, oracle := range new {
+		if match, ok := old[id]; ok {
+			pairs = append(pairs, &OraclePair{Old: match, New: oracle})
+		} else if olds, news := oldNames[oracle.Name], newNames[oracle.Name]; len(olds) == 1 && len(news) == 1 {
+			pairs = append(pairs, &OraclePair{Old: olds[0], New: oracle})
+		} else {
+			added = append(added, oracle)
+		}
+	}
+	for id, oracle := range old {
+		if _, ok := new[id]; ok {
+			continue
+		}
+		if olds, news := oldNames[oracle.Name], newNames[oracle.Name]; len(olds) != 1 || len(news) != 1 {
+			removed = append(removed, oracle)
+		}
+	}
+	sortOracles := func(oracles []*OracleInfo) {
+		sort.Slice(oracles, func(i, j int) bool { return oracles[i].Name < oracles[j].Name })
+	}
+	sortOracles(added)
+	sortOracles(removed)
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].New.Name < pairs[j].New.Name })
+	return pairs, added, removed
+}
+
+func statementDelta(ctx context.Context, conn *sql.Conn, from, to string, examples int) (count int64, sample []*Statement, err error) {
+	missing := "FROM " + from + ".statements AS stmt WHERE stmt.id NOT IN (SELECT id FROM " + to + ".statements)"
+	if err := conn.QueryRowContext(ctx, "SELECT count(*) "+missing).Scan(&count); err != nil {
+		return 0, nil, err
+	}
+	limit := examples
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := conn.QueryContext(ctx, "SELECT stmt.id, stmt.text "+missing+" ORDER BY stmt.id LIMIT ?", limit)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+	sample = []*Statement{}
+	for rows.Next() {
+		var statement Statement
+		if err := rows.Scan(&statement.Id, &statement.Text); err != nil {
+			return 0, nil, err
+		}
+		sample = append(sample, &statement)
+	}
+	return count, sample, rows.Err()
+}
+
+// DiffCorpora compares the corpus at oldPath to db, keeping up to examples
+// statements (or all of them, if examples is 0) per kind of change.
+func DiffCorpora(ctx context.Context, db *sql.DB, oldPath string, examples int) (*CorpusDiff, error) {
+	// attached databases and temp tables only exist on the connection that
+	// made them
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS old", oldPath); err != nil {
+		return nil, err
+	}
+	defer conn.ExecContext(context.Background(), "DETACH DATABASE old")
+
+	diff := CorpusDiff{}
+	diff.AddedStatements, diff.AddedExamples, err = statementDelta(ctx, conn, "main", "old", examples)
+	if err != nil {
+		return nil, err
+	}
+	diff.RemovedStatements, diff.RemovedExamples, err = statementDelta(ctx, conn, "old", "main", examples)
+	if err != nil {
+		return nil, err
+	}
+
+	oldOracles, err := getOracles(ctx, conn, "old")
+	if err != nil {
+		return nil, err
+	}
+	newOracles, err := getOracles(ctx, conn, "main")
+	if err != nil {
+		return nil, err
+	}
+	diff.Oracles, diff.AddedOracles, diff.RemovedOracles = pairOracles(oldOracles, newOracles)
+
+	steps := []string{
+		"DROP TABLE IF EXISTS temp.oracle_pairs",
+		"CREATE TEMP TABLE oracle_pairs (old_id INTEGER, new_id INTEGER, PRIMARY KEY (old_id, new_id))",
+	}
+	for _, step := range steps {
+		if _, err := conn.ExecContext(ctx, step); err != nil {
+			return nil, err
+		}
+	}
+	defer conn.ExecContext(context.Background(), "DROP TABLE IF EXISTS temp.oracle_pairs")
+	type key struct {
+		oldId, newId int64
+		was, now     string
+	}
+	pairs := map[[2]int64]*OraclePair{}
+	for _, pair := range diff.Oracles {
+		pairs[[2]int64{pair.Old.Id, pair.New.Id}] = pair
+		_, err := conn.ExecContext(ctx,
+			"INSERT INTO temp.oracle_pairs (old_id, new_id) VALUES (?, ?)", pair.Old.Id, pair.New.Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	changes := map[key]*ValidityChange{}
+	rows, err := conn.QueryContext(ctx, diffPredictionsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k key
+		var count int64
+		if err := rows.Scan(&k.oldId, &k.newId, &k.was, &k.now, &count); err != nil {
+			return nil, err
+		}
+		pair := pairs[[2]int64{k.oldId, k.newId}]
+		pair.Compared += count
+		if k.was != k.now {
+			change := &ValidityChange{Was: k.was, Now: k.now, Count: count, Examples: []*ChangedPrediction{}}
+			pair.Changes = append(pair.Changes, change)
+			changes[k] = change
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = conn.QueryContext(ctx, diffPredictionExamplesQuery, sql.Named("examples", examples))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k key
+		var example ChangedPrediction
+		var oldError, newError sql.NullString
+		err := rows.Scan(&k.oldId, &k.newId, &k.was, &k.now, &example.StatementId, &example.Text, &oldError, &newError)
+		if err != nil {
+			return nil, err
+		}
+		example.OldError, example.NewError = oldError.String, newError.String
+		if change, ok := changes[k]; ok {
+			change.Examples = append(change.Examples, &example)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, pair := range diff.Oracles {
+		sort.Slice(pair.Changes, func(i, j int) bool { return pair.Changes[i].Count > pair.Changes[j].Count })
+	}
+	return &diff, nil
+}
@@ -0,0 +1,140 @@
+package corpus
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+)
+
+// a DocumentSource lists where a document was found.
+type DocumentSource struct {
+	Urls []string
+	// the distinct licenses of the urls, if known
+	Licenses []string
+}
+
+//go:embed sql/get_document_sources.sql
+var getDocumentSourcesQuery string
+
+// GetDocumentSources maps each document id to the urls it was found at.
+func GetDocumentSources(ctx context.Context, db *sql.DB) (map[int64]*DocumentSource, error) {
+	rows, err := db.QueryContext(ctx, getDocumentSourcesQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := map[int64]*DocumentSource{}
+	for rows.Next() {
+		var documentId int64
+		var url string
+		var license sql.NullString
+		if err := rows.Scan(&documentId, &url, &license); err != nil {
+			return nil, err
+		}
+		source, ok := result[documentId]
+		if !ok {
+			source = &DocumentSource{Urls: []string{}, Licenses: []string{}}
+			result[documentId] = source
+		}
+		source.Urls = append(source.Urls, url)
+		if license.Valid {
+			seen := false
+			for _, id := range source.Licenses {
+				seen = seen || id == license.String
+			}
+			if !seen {
+				source.Licenses = append(source.Licenses, license.String)
+			}
+		}
+	}
+	return result, rows.Err()
+}
+
+// a Verdict is a prediction along with its recorded validity, which is set
+// even for predictions made before outcomes were.
+type Verdict struct {
+	Prediction
+	Valid *bool
+}
+
+// a DocumentStatement is a statement at one position in a document, along
+// with every prediction about it in one language.
+type DocumentStatement struct {
+	DocumentId  int64
+	StatementId int64
+	// the statement's position among all of the document's statements, in any
+	// language, from 0
+	Ordinal     int
+	StartOffset int64
+	StartLine   int64
+	EndLine     int64
+	Text        string
+	LanguageId  int64
+	Predictions []*Verdict
+}
+
+//go:embed sql/get_document_statements.sql
+var getDocumentStatementsQuery string
+
+// EachDocumentStatement streams the statements of every document in the
+// language, in order of document then position within the document.
+func EachDocumentStatement(
+	ctx context.Context,
+	db *sql.DB,
+	languageId int64,
+	fn func(*DocumentStatement) error,
+) error {
+	rows, err := db.QueryContext(ctx, getDocumentStatementsQuery, sql.Named("language_id", languageId))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var current *DocumentStatement
+	for rows.Next() {
+		var row DocumentStatement
+		var startLine, endLine, startOffset, oracleId sql.NullInt64
+		var outcome, errorText sql.NullString
+		var valid sql.NullBool
+		err := rows.Scan(
+			&row.DocumentId, &row.StatementId, &row.Ordinal, &startOffset, &startLine, &endLine,
+			&row.Text, &oracleId, &outcome, &valid, &errorText,
+		)
+		if err != nil {
+			return err
+		}
+		if current == nil || current.DocumentId != row.DocumentId ||
+			current.StatementId != row.StatementId || current.StartOffset != startOffset.Int64 {
+			if current != nil {
+				if err := fn(current); err != nil {
+					return err
+				}
+			}
+			row.StartOffset = startOffset.Int64
+			row.StartLine = startLine.Int64
+			row.EndLine = endLine.Int64
+			row.LanguageId = languageId
+			row.Predictions = []*Verdict{}
+			current = &row
+		}
+		if oracleId.Valid {
+			verdict := Verdict{Prediction: Prediction{
+				StatementId: row.StatementId,
+				OracleId:    oracleId.Int64,
+				LanguageId:  languageId,
+				Outcome:     Outcome(outcome.String),
+				Error:       errorText.String,
+			}}
+			if valid.Valid {
+				verdict.Valid = &valid.Bool
+			}
+			current.Predictions = append(current.Predictions, &verdict)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if current != nil {
+		return fn(current)
+	}
+	return nil
+}
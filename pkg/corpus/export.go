@@ -0,0 +1,168 @@
+package corpus
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"fmt"
+	"strings"
+)
+
+// an ExportFilter narrows down which statements and predictions to export.
+// Zero values match everything.
+type ExportFilter struct {
+	LanguageId *int64
+	// oracle names or hexadecimal ids; only their predictions are exported
+	Oracles []string
+	// only predictions from oracles for these versions are exported
+	Versions []string
+	// "valid", "invalid", or "unknown" (the prediction has no verdict)
+	Validity string
+	// only statements from urls under this license, e.g. "PostgreSQL"
+	LicenseId string
+	ConstructFilter
+}
+
+func (filter *ExportFilter) filtersOracles() bool {
+	return len(filter.Oracles) > 0 || len(filter.Versions) > 0
+}
+
+// matchOracles resolves the oracle and version filters to a set of oracle ids.
+func (filter *ExportFilter) matchOracles(oracles map[int64]*OracleInfo) (map[int64]bool, error) {
+	result := map[int64]bool{}
+	for id, oracle := range oracles {
+		result[id] = true
+		if len(filter.Versions) > 0 {
+			result[id] = false
+			for _, version := range filter.Versions {
+				if oracle.Version() == version {
+					result[id] = true
+				}
+			}
+		}
+	}
+	if len(filter.Oracles) == 0 {
+		return result, nil
+	}
+	named := map[int64]bool{}
+	for _, name := range filter.Oracles {
+		found := false
+		for id, oracle := range oracles {
+			if oracle.Name == name || strings.EqualFold(fmt.Sprintf("%x", uint64(id)), name) {
+				named[id] = result[id]
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown oracle %s", name)
+		}
+	}
+	return named, nil
+}
+
+// an ExportRecord is a statement in one of its languages along with one of
+// its predictions, if any, and where the statement came from.
+type ExportRecord struct {
+	StatementId int64
+	Text        string
+	Language    string
+	// the prediction's fields are empty if the statement has no predictions
+	OracleId int64
+	Oracle   string
+	Version  string
+	Outcome  Outcome
+	Valid    *bool
+	Error    string
+	Urls     []string
+	Licenses []string
+}
+
+//go:embed sql/export.sql
+var exportQuery string
+
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func splitLines(s sql.NullString) []string {
+	if !s.Valid || s.String == "" {
+		return []string{}
+	}
+	return strings.Split(s.String, "\n")
+}
+
+// EachExportRecord streams the records matching the filter in order of
+// statement id.
+func EachExportRecord(
+	ctx context.Context,
+	db *sql.DB,
+	filter ExportFilter,
+	fn func(*ExportRecord) error,
+) error {
+	switch filter.Validity {
+	case "", "valid", "invalid", "unknown":
+	default:
+		return fmt.Errorf("unknown validity %s; expected valid, invalid, or unknown", filter.Validity)
+	}
+	oracles, err := GetOracles(ctx, db)
+	if err != nil {
+		return err
+	}
+	var included map[int64]bool
+	if filter.filtersOracles() {
+		if included, err = filter.matchOracles(oracles); err != nil {
+			return err
+		}
+	}
+	params := append([]interface{}{
+		sql.Named("language_id", filter.LanguageId),
+		sql.Named("validity", nullable(filter.Validity)),
+		sql.Named("license_id", nullable(filter.LicenseId)),
+	}, filter.ConstructFilter.params()...)
+	rows, err := db.QueryContext(ctx, exportQuery, params...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var record ExportRecord
+		var language, outcome, errorText, urls, licenses sql.NullString
+		var oracleId sql.NullInt64
+		var valid sql.NullBool
+		err := rows.Scan(
+			&record.StatementId, &record.Text, &language,
+			&oracleId, &outcome, &valid, &errorText,
+			&urls, &licenses,
+		)
+		if err != nil {
+			return err
+		}
+		if included != nil && !(oracleId.Valid && included[oracleId.Int64]) {
+			continue
+		}
+		record.Language = language.String
+		if oracleId.Valid {
+			record.OracleId = oracleId.Int64
+			if oracle, ok := oracles[oracleId.Int64]; ok {
+				record.Oracle = oracle.Name
+				record.Version = oracle.Version()
+			} else {
+				record.Oracle = fmt.Sprintf("%x", uint64(oracleId.Int64))
+			}
+		}
+		record.Outcome = Outcome(outcome.String)
+		if valid.Valid {
+			record.Valid = &valid.Bool
+		}
+		record.Error = errorText.String
+		record.Urls = splitLines(urls)
+		record.Licenses = splitLines(licenses)
+		if err := fn(&record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,53 @@
+package corpus
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+
+	pg_query "github.com/pganalyze/pg_query_go/v2"
+)
+
+// BackfillFingerprints fingerprints the pgsql statements that don't have a
+// fingerprint yet, like the splitter does while ingesting. It reports how many
+// statements it fingerprinted and how many pg_query couldn't parse, which
+// stay without a fingerprint.
+func BackfillFingerprints(ctx context.Context, db *sql.DB) (added int64, unparseable int64, err error) {
+	err = backfill(ctx, db, "statement_fingerprints", func(txn *sql.Tx, statement *Statement) error {
+		fingerprint, err := pg_query.FingerprintToUInt64(statement.Text)
+		if err != nil {
+			unparseable++
+			return nil
+		}
+		_, err = txn.ExecContext(ctx,
+			"INSERT INTO statement_fingerprints (statement_id, fingerprint) VALUES (?, ?) ON CONFLICT DO NOTHING",
+			statement.Id, int64(fingerprint),
+		)
+		if err == nil {
+			added++
+		}
+		return err
+	})
+	return added, unparseable, err
+}
+
+//go:embed sql/propagate_predictions.sql
+var propagatePredictionsQuery string
+
+// PropagatePredictions copies the oracle's predictions in the language to the
+// statements that share a fingerprint with a statement it predicted and that
+// it hasn't predicted itself, marking the copies as inferred from the
+// predicted statement. Each group's lowest predicted statement id wins. The
+// copies keep the verdict and error but not the message, whose e.g. token
+// offsets only describe the predicted statement. It returns how many
+// predictions it added.
+func PropagatePredictions(ctx context.Context, db *sql.DB, oracleId int64, languageId int64) (int64, error) {
+	result, err := db.ExecContext(ctx, propagatePredictionsQuery,
+		sql.Named("oracle_id", oracleId),
+		sql.Named("language_id", languageId),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,257 @@
+package corpus
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v2"
+	pg_sql_tests "github.com/skalt/pg_sql_tests"
+	"github.com/skalt/pg_sql_tests/pkg/languages"
+)
+
+// HashId derives an id for a document, statement, or url the same way the
+// splitter does: the xxh3_64 of its utf-8 bytes.
+func HashId(text string) int64 {
+	return int64(pg_query.HashXXH3_64([]byte(text), 0))
+}
+
+// Create initializes a new corpus database at path from the embedded schema.
+func Create(path string) (*sql.DB, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("%s already exists", path)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(pg_sql_tests.Schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// ConnectOrCreate opens the corpus database at path, creating it first if it
+// doesn't exist yet.
+func ConnectOrCreate(path string) (*sql.DB, error) {
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return Create(path)
+	}
+	return ConnectToExisting(path)
+}
+
+// a Split is a statement's text as it appears in a document, along with the
+// language the statement was written in and where the splitter found it.
+type Split struct {
+	Text       string
+	LanguageId int64
+	Span       Span
+}
+
+// a Span locates a statement within a document. Lines are 1-indexed; offsets
+// are 0-indexed and count bytes. Like both splitters, EndLine is StartLine
+// plus the number of newlines in the statement.
+type Span struct {
+	StartLine   int
+	EndLine     int
+	StartOffset int
+	EndOffset   int
+}
+
+// an Ingester adds documents, statements, and their sources to a corpus in a
+// single transaction. It isn't safe for concurrent use.
+type Ingester struct {
+	ctx      context.Context
+	txn      *sql.Tx
+	prepared map[string]*sql.Stmt
+}
+
+func NewIngester(ctx context.Context, db *sql.DB) (*Ingester, error) {
+	txn, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Ingester{ctx: ctx, txn: txn, prepared: map[string]*sql.Stmt{}}, nil
+}
+
+// exec runs the query, preparing it the first time it's used.
+func (in *Ingester) exec(query string, args ...interface{}) error {
+	stmt, ok := in.prepared[query]
+	if !ok {
+		var err error
+		if stmt, err = in.txn.PrepareContext(in.ctx, query); err != nil {
+			return err
+		}
+		in.prepared[query] = stmt
+	}
+	_, err := stmt.ExecContext(in.ctx, args...)
+	return err
+}
+
+// AddLicense records the full text of a license, replacing any previous text.
+// The id should be an SPDX identifier where possible.
+func (in *Ingester) AddLicense(id string, text string) error {
+	return in.exec(
+		"INSERT INTO licenses (id, text) VALUES (?, ?) ON CONFLICT(id) DO UPDATE SET text = excluded.text",
+		id, text,
+	)
+}
+
+// AddUrl records a url under an optional license.
+func (in *Ingester) AddUrl(url string, licenseId string) (id int64, err error) {
+	id = HashId(url)
+	var license interface{} = nil
+	if licenseId != "" {
+		license = licenseId
+	}
+	err = in.exec(
+		"INSERT INTO urls (id, url, license_id) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+		id, url, license,
+	)
+	return id, err
+}
+
+func (in *Ingester) HasDocument(id int64) (bool, error) {
+	var count int
+	err := in.txn.QueryRowContext(in.ctx, "SELECT count(*) FROM documents WHERE id = ?", id).Scan(&count)
+	return count > 0, err
+}
+
+func (in *Ingester) AddDocument(id int64) error {
+	return in.exec("INSERT INTO documents (id) VALUES (?) ON CONFLICT DO NOTHING", id)
+}
+
+func (in *Ingester) AddDocumentUrl(documentId int64, urlId int64) error {
+	return in.exec(
+		"INSERT INTO document_urls (document_id, url_id) VALUES (?, ?) ON CONFLICT DO NOTHING",
+		documentId, urlId,
+	)
+}
+
+func (in *Ingester) AddStatement(text string) (id int64, err error) {
+	id = HashId(text)
+	err = in.exec("INSERT INTO statements (id, text) VALUES (?, ?) ON CONFLICT(id) DO NOTHING", id, text)
+	return id, err
+}
+
+func (in *Ingester) AddStatementLanguage(statementId int64, languageId int64) error {
+	return in.exec(
+		"INSERT INTO statement_languages (statement_id, language_id) VALUES (?, ?) ON CONFLICT DO NOTHING",
+		statementId, languageId,
+	)
+}
+
+func (in *Ingester) AddStatementSource(documentId int64, statementId int64, span Span) error {
+	return in.exec(
+		"INSERT INTO document_statements"+
+			" (document_id, statement_id, start_line, end_line, start_offset, end_offset)"+
+			" VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING",
+		documentId, statementId, span.StartLine, span.EndLine, span.StartOffset, span.EndOffset,
+	)
+}
+
+func (in *Ingester) AddFingerprint(statementId int64, fingerprint int64) error {
+	return in.exec(
+		"INSERT INTO statement_fingerprints (statement_id, fingerprint) VALUES (?, ?) ON CONFLICT DO NOTHING",
+		statementId, fingerprint,
+	)
+}
+
+// IngestDocument adds a document found at the given urls along with its
+// statements, mirroring the splitter: pgsql statements get fingerprinted, and
+// DO blocks and function definitions are also tagged with their procedural
+// language. The splits must tile the document's text, with each split's span
+// following the last one's. A document that's
+// already in the corpus only gains the urls. It returns the number of
+// statements it added, counting duplicates.
+func (in *Ingester) IngestDocument(text string, urlIds []int64, splits []Split) (n int, err error) {
+	documentId := HashId(text)
+	for _, urlId := range urlIds {
+		if err := in.AddDocumentUrl(documentId, urlId); err != nil {
+			return 0, err
+		}
+	}
+	if done, err := in.HasDocument(documentId); err != nil || done {
+		return 0, err
+	}
+	if err := in.AddDocument(documentId); err != nil {
+		return 0, err
+	}
+	line, offset := 1, 0
+	for _, split := range splits {
+		span := split.Span
+		if span.StartLine != line || span.StartOffset != offset || span.EndOffset != offset+len(split.Text) {
+			return n, fmt.Errorf("split %d's span %+v doesn't follow line %d, offset %d", n, span, line, offset)
+		}
+		if !strings.HasPrefix(text[offset:], split.Text) {
+			return n, fmt.Errorf("split %d doesn't match the document at offset %d", n, offset)
+		}
+		statementId, err := in.AddStatement(split.Text)
+		if err != nil {
+			return n, err
+		}
+		if err := in.AddStatementSource(documentId, statementId, span); err != nil {
+			return n, err
+		}
+		if err := in.AddStatementLanguage(statementId, split.LanguageId); err != nil {
+			return n, err
+		}
+		if split.LanguageId == languages.Pgsql {
+			if fingerprint, err := pg_query.FingerprintToUInt64(split.Text); err == nil {
+				if err := in.AddFingerprint(statementId, int64(fingerprint)); err != nil {
+					return n, err
+				}
+			}
+		}
+		if pl, ok := proceduralLanguage(split.Text); ok {
+			if err := in.AddStatementLanguage(statementId, pl); err != nil {
+				return n, err
+			}
+		}
+		line, offset = span.EndLine, span.EndOffset
+		n++
+	}
+	if offset != len(text) {
+		return n, fmt.Errorf("the splits only cover %d of %d bytes", offset, len(text))
+	}
+	return n, nil
+}
+
+// proceduralLanguage recognizes DO blocks and function definitions, returning
+// the language their body is written in.
+func proceduralLanguage(text string) (languageId int64, ok bool) {
+	tree, err := pg_query.Parse(text)
+	if err != nil || len(tree.Stmts) == 0 {
+		return 0, false
+	}
+	var options []*pg_query.Node
+	if do := tree.Stmts[0].GetStmt().GetDoStmt(); do != nil {
+		options = do.Args
+	} else if fn := tree.Stmts[0].GetStmt().GetCreateFunctionStmt(); fn != nil {
+		options = fn.Options
+	} else {
+		return 0, false
+	}
+	name := "plpgsql" // the default
+	for _, option := range options {
+		if def := option.GetDefElem(); def != nil && def.Defname == "language" {
+			name = def.Arg.GetString_().Str
+		}
+	}
+	return languages.IdentifyProcedural(name), true
+}
+
+func (in *Ingester) Commit() error {
+	return in.txn.Commit()
+}
+
+func (in *Ingester) Rollback() error {
+	return in.txn.Rollback()
+}
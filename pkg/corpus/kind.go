@@ -0,0 +1,57 @@
+package corpus
+
+import (
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v2"
+)
+
+// StatementKind names the top-level kind of a statement, e.g. "SelectStmt".
+// Statements that don't parse fall back to their leading keyword, e.g.
+// "SELECT", or to their meta-command, e.g. `\dt`.
+func StatementKind(text string) string {
+	if tree, err := pg_query.Parse(text); err == nil && len(tree.Stmts) > 0 {
+		if node := tree.Stmts[0].GetStmt(); node != nil {
+			message := node.ProtoReflect()
+			if field := message.WhichOneof(message.Descriptor().Oneofs().ByName("node")); field != nil {
+				return string(field.Message().Name())
+			}
+		}
+	}
+	return leadingKeyword(text)
+}
+
+// leadingKeyword finds the first word after any whitespace and comments.
+func leadingKeyword(text string) string {
+	for {
+		text = strings.TrimSpace(text)
+		if strings.HasPrefix(text, "--") {
+			if end := strings.IndexByte(text, '\n'); end >= 0 {
+				text = text[end:]
+				continue
+			}
+			return ""
+		}
+		if strings.HasPrefix(text, "/*") {
+			if end := strings.Index(text, "*/"); end >= 0 {
+				text = text[end+2:]
+				continue
+			}
+			return ""
+		}
+		break
+	}
+	end := strings.IndexFunc(text, func(r rune) bool {
+		return !(r == '_' || r == '\\' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'))
+	})
+	if end < 0 {
+		end = len(text)
+	}
+	if end == 0 {
+		return ""
+	}
+	if text[0] == '\\' {
+		return text[:end] // meta-commands are case-sensitive
+	}
+	return strings.ToUpper(text[:end])
+}
@@ -0,0 +1,93 @@
+package corpus
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// a Language is a row of the languages table. Every corpus starts with the
+// languages in package languages; others are added with AddLanguage.
+type Language struct {
+	Id   int64
+	Name string
+	// the family of versions the language's statements are tested against,
+	// e.g. "postgres", if any
+	Family string
+	// the version of the family the language is pinned to, if any
+	Version string
+}
+
+// DeriveLanguageId hashes the name of a language that isn't built in, so that
+// corpora that add the same language agree on its id.
+func DeriveLanguageId(name string) int64 {
+	return int64(xxhash.Sum64String(name))
+}
+
+// ErrUnknownLanguage means a corpus' languages table has no such language.
+var ErrUnknownLanguage = errors.New("unknown language")
+
+// GetLanguages lists every language in the corpus in order of id.
+func GetLanguages(ctx context.Context, db *sql.DB) ([]*Language, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id, "name", family, "version" FROM languages ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []*Language
+	for rows.Next() {
+		var language Language
+		var family, version sql.NullString
+		if err := rows.Scan(&language.Id, &language.Name, &family, &version); err != nil {
+			return nil, err
+		}
+		language.Family, language.Version = family.String, version.String
+		result = append(result, &language)
+	}
+	return result, rows.Err()
+}
+
+// LookupLanguage finds a language by name, returning an error wrapping
+// ErrUnknownLanguage if the corpus doesn't have it.
+func LookupLanguage(ctx context.Context, db *sql.DB, name string) (*Language, error) {
+	language := Language{Name: name}
+	var family, version sql.NullString
+	err := db.QueryRowContext(ctx,
+		`SELECT id, family, "version" FROM languages WHERE "name" = ?`, name,
+	).Scan(&language.Id, &family, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w %s", ErrUnknownLanguage, name)
+	} else if err != nil {
+		return nil, err
+	}
+	language.Family, language.Version = family.String, version.String
+	return &language, nil
+}
+
+// AddLanguage registers a new language under the id DeriveLanguageId gives its
+// name, filling in language.Id.
+func AddLanguage(ctx context.Context, db *sql.DB, language *Language) error {
+	if language.Name == "" {
+		return fmt.Errorf("a language needs a name")
+	}
+	if existing, err := LookupLanguage(ctx, db, language.Name); err == nil {
+		return fmt.Errorf("language %s already exists with id %d", language.Name, existing.Id)
+	} else if !errors.Is(err, ErrUnknownLanguage) {
+		return err
+	}
+	nullable := func(s string) interface{} {
+		if s == "" {
+			return nil
+		}
+		return s
+	}
+	language.Id = DeriveLanguageId(language.Name)
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO languages (id, "name", family, "version") VALUES (?, ?, ?, ?)`,
+		language.Id, language.Name, nullable(language.Family), nullable(language.Version),
+	)
+	return err
+}
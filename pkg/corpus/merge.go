@@ -0,0 +1,326 @@
+package corpus
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// a MergePolicy decides what happens when the corpus being merged in has a
+// different verdict than the merged corpus on the same statement, oracle, and
+// language.
+type MergePolicy string
+
+const (
+	// keep the prediction that was merged first
+	KeepFirst MergePolicy = "keep-first"
+	// replace it with the prediction from the corpus merged last
+	KeepLatest MergePolicy = "keep-latest"
+	// stop merging
+	FailOnConflict MergePolicy = "fail"
+	// keep the first prediction and set the other aside in prediction_conflicts
+	RecordBoth MergePolicy = "record-both"
+)
+
+var MergePolicies = []MergePolicy{KeepFirst, KeepLatest, FailOnConflict, RecordBoth}
+
+func ParseMergePolicy(name string) (MergePolicy, error) {
+	for _, policy := range MergePolicies {
+		if string(policy) == name {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("unknown merge policy %s; expected one of %v", name, MergePolicies)
+}
+
+var (
+	// two different rows hash to the same id
+	ErrCollision = errors.New("hash collision")
+	// the corpora disagree about a prediction and the policy is FailOnConflict
+	ErrConflict = errors.New("conflicting predictions")
+)
+
+// a Collision is a pair of different rows with the same key, e.g. statements
+// whose texts hash to the same id.
+type Collision struct {
+	Table    string
+	Key      string
+	Existing string
+	Incoming string
+}
+
+// a PredictionConflict is a pair of predictions with different verdicts on
+// the same statement, oracle, and language.
+type PredictionConflict struct {
+	Existing *Verdict
+	Incoming *Verdict
+}
+
+// a MergeSummary describes what merging one corpus into another did.
+type MergeSummary struct {
+	Source string
+	// the rows added to each table, in the order they were merged
+	Tables   []string
+	Inserted map[string]int64
+	// predictions already present with the same verdict
+	Duplicates int64
+	Conflicts  int64
+	// up to the requested number of conflicts
+	ConflictExamples []*PredictionConflict
+	Collisions       []*Collision
+}
+
+// the columns of each table to merge, in an order that satisfies foreign keys.
+// Predictions are merged separately according to the policy.
+var mergedTables = []struct{ name, columns string }{
+	{"languages", `id, "name", family, "version"`},
+	{"versions", `id, family, "version"`},
+	{"language_versions", "language_id, version_id"},
+	{"statements", `id, "text"`},
+	{"statement_languages", "statement_id, language_id"},
+	{"statement_fingerprints", "fingerprint, statement_id"},
+	{"statement_tokens", "statement_id, ordinal, token, keyword_kind, start_offset, end_offset"},
+	{"statement_nodes", `statement_id, node, top_level, "count"`},
+	{"statement_options", `statement_id, "option"`},
+	{"statement_versions", "statement_id, version_id"},
+	{"documents", "id"},
+	{"licenses", `id, "text"`},
+	{"urls", `id, "url", license_id`},
+	{"document_urls", "document_id, url_id"},
+	{"document_statements", "document_id, statement_id, start_line, start_offset, end_line, end_offset, locator"},
+	{"oracles", `id, "name"`},
+	{"oracle_metadata", `oracle_id, "key", "value"`},
+	{"derived_statements", "statement_id, parent_id, derivation, oracle_id"},
+}
+
+const predictionColumns = `statement_id, oracle_id, language_id, error, "message", valid, outcome, inferred_from`
+
+// queries for rows that would be silently dropped by INSERT OR IGNORE because
+// a different row already has the same key. Each yields table, key, existing,
+// and incoming values.
+var collisionQueries = []string{
+	`SELECT 'statements', printf('%x', other.id), main.text, other.text
+	FROM other.statements AS other JOIN main.statements AS main ON main.id = other.id
+	WHERE main.text IS NOT other.text`,
+	`SELECT 'urls', printf('%x', other.id), main.url, other.url
+	FROM other.urls AS other JOIN main.urls AS main ON main.id = other.id
+	WHERE main.url IS NOT other.url`,
+	`SELECT 'urls', other.url, printf('%x', main.id), printf('%x', other.id)
+	FROM other.urls AS other JOIN main.urls AS main ON main.url = other.url
+	WHERE main.id IS NOT other.id`,
+	`SELECT 'urls', other.url, main.license_id, other.license_id
+	FROM other.urls AS other JOIN main.urls AS main ON main.id = other.id
+	WHERE main.license_id IS NOT other.license_id`,
+	`SELECT 'licenses', other.id, substr(main.text, 1, 80), substr(other.text, 1, 80)
+	FROM other.licenses AS other JOIN main.licenses AS main ON main.id = other.id
+	WHERE main.text IS NOT other.text`,
+	`SELECT 'languages', other.id,
+	  main.name || ifnull(' ' || main.family, '') || ifnull(' ' || main.version, ''),
+	  other.name || ifnull(' ' || other.family, '') || ifnull(' ' || other.version, '')
+	FROM other.languages AS other JOIN main.languages AS main ON main.id = other.id OR main.name = other.name
+	WHERE main.id IS NOT other.id OR main.name IS NOT other.name
+	  OR main.family IS NOT other.family OR main.version IS NOT other.version`,
+	`SELECT 'versions', printf('%x', other.id), main.family || ' ' || main.version, other.family || ' ' || other.version
+	FROM other.versions AS other JOIN main.versions AS main ON main.id = other.id
+	WHERE main.family IS NOT other.family OR main.version IS NOT other.version`,
+	`SELECT 'oracles', printf('%x', other.id), main.name, other.name
+	FROM other.oracles AS other JOIN main.oracles AS main ON main.id = other.id
+	WHERE main.name IS NOT other.name`,
+	`SELECT 'oracle_metadata', printf('%x', other.oracle_id) || ' ' || other.key, main.value, other.value
+	FROM other.oracle_metadata AS other
+	JOIN main.oracle_metadata AS main ON main.oracle_id = other.oracle_id AND main.key = other.key
+	WHERE main.value IS NOT other.value`,
+}
+
+const conflictingPredictions = `
+	FROM other.predictions AS other
+	JOIN main.predictions AS main
+	  ON  main.statement_id = other.statement_id
+	  AND main.oracle_id = other.oracle_id
+	  AND main.language_id = other.language_id
+	WHERE main.valid IS NOT other.valid OR main.outcome IS NOT other.outcome`
+
+// Merge copies the corpus at path into db in one transaction, checking for
+// hash collisions and resolving conflicting predictions according to the
+// policy. It keeps up to examples conflicts in the summary, or all of them
+// if examples is 0. It returns ErrCollision if any different rows share a
+// key, in which case nothing is merged.
+func Merge(ctx context.Context, db *sql.DB, path string, policy MergePolicy, examples int) (*MergeSummary, error) {
+	other, err := ConnectToExisting(path)
+	if err != nil {
+		if other != nil {
+			other.Close()
+		}
+		return nil, err
+	}
+	other.Close()
+
+	summary := MergeSummary{Source: path, Inserted: map[string]int64{}}
+	// attached databases only exist on the connection that attached them
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS other", path); err != nil {
+		return nil, err
+	}
+	defer conn.ExecContext(context.Background(), "DETACH DATABASE other")
+	txn, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer txn.Rollback()
+
+	for _, query := range collisionQueries {
+		rows, err := txn.QueryContext(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var collision Collision
+			var existing, incoming sql.NullString
+			if err := rows.Scan(&collision.Table, &collision.Key, &existing, &incoming); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			collision.Existing, collision.Incoming = existing.String, incoming.String
+			summary.Collisions = append(summary.Collisions, &collision)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if len(summary.Collisions) > 0 {
+		return &summary, fmt.Errorf("%w: %d rows in %s collide with merged rows", ErrCollision, len(summary.Collisions), path)
+	}
+
+	err = txn.QueryRowContext(ctx, `
+		SELECT count(*), coalesce(sum(main.valid IS NOT other.valid OR main.outcome IS NOT other.outcome), 0)
+		FROM other.predictions AS other
+		JOIN main.predictions AS main
+		  ON  main.statement_id = other.statement_id
+		  AND main.oracle_id = other.oracle_id
+		  AND main.language_id = other.language_id`,
+	).Scan(&summary.Duplicates, &summary.Conflicts)
+	if err != nil {
+		return nil, err
+	}
+	summary.Duplicates -= summary.Conflicts
+	if summary.Conflicts > 0 {
+		if summary.ConflictExamples, err = conflictExamples(ctx, txn, examples); err != nil {
+			return nil, err
+		}
+		if policy == FailOnConflict {
+			return &summary, fmt.Errorf("%w: %d predictions in %s", ErrConflict, summary.Conflicts, path)
+		}
+	}
+
+	insert := func(table string, query string, args ...interface{}) error {
+		result, err := txn.ExecContext(ctx, query, args...)
+		if err != nil {
+			return fmt.Errorf("merging %s: %w", table, err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if _, ok := summary.Inserted[table]; !ok {
+			summary.Tables = append(summary.Tables, table)
+		}
+		summary.Inserted[table] += n
+		return nil
+	}
+	for _, table := range mergedTables {
+		query := fmt.Sprintf(
+			"INSERT OR IGNORE INTO main.%s (%s) SELECT %s FROM other.%s",
+			table.name, table.columns, table.columns, table.name,
+		)
+		if err := insert(table.name, query); err != nil {
+			return nil, err
+		}
+	}
+	if policy == RecordBoth {
+		query := "INSERT INTO main.prediction_conflicts (" + predictionColumns + ", source) " +
+			"SELECT " + prefixColumns("other", predictionColumns) + ", :source " + conflictingPredictions
+		if err := insert("prediction_conflicts", query, sql.Named("source", path)); err != nil {
+			return nil, err
+		}
+	}
+	predictions := "INSERT OR IGNORE INTO main.predictions (" + predictionColumns + ") " +
+		"SELECT " + predictionColumns + " FROM other.predictions"
+	if policy == KeepLatest {
+		// only replace predictions with different verdicts, so that the
+		// summary's counts add up
+		predictions = "INSERT INTO main.predictions (" + predictionColumns + ") " +
+			"SELECT " + predictionColumns + " FROM other.predictions WHERE true " +
+			`ON CONFLICT (statement_id, oracle_id, language_id) DO UPDATE SET
+				error = excluded.error, "message" = excluded."message",
+				valid = excluded.valid, outcome = excluded.outcome, inferred_from = excluded.inferred_from
+			WHERE valid IS NOT excluded.valid OR outcome IS NOT excluded.outcome`
+	}
+	if err := insert("predictions", predictions); err != nil {
+		return nil, err
+	}
+	// keep conflicts that were set aside when the incoming corpus was merged
+	query := "INSERT INTO main.prediction_conflicts (" + predictionColumns + ", source) " +
+		"SELECT " + predictionColumns + ", source FROM other.prediction_conflicts"
+	if err := insert("prediction_conflicts", query); err != nil {
+		return nil, err
+	}
+	return &summary, txn.Commit()
+}
+
+func prefixColumns(table string, columns string) string {
+	names := strings.Split(columns, ", ")
+	for i, name := range names {
+		names[i] = table + "." + name
+	}
+	return strings.Join(names, ", ")
+}
+
+func conflictExamples(ctx context.Context, txn *sql.Tx, examples int) ([]*PredictionConflict, error) {
+	limit := examples
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := txn.QueryContext(ctx, `
+		SELECT other.statement_id, other.oracle_id, other.language_id,
+		       main.outcome, main.valid, main.error,
+		       other.outcome, other.valid, other.error`+
+		conflictingPredictions+`
+		ORDER BY other.statement_id, other.oracle_id, other.language_id
+		LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []*PredictionConflict{}
+	for rows.Next() {
+		var key Prediction
+		var outcomes, errorTexts [2]sql.NullString
+		var valid [2]sql.NullBool
+		err := rows.Scan(
+			&key.StatementId, &key.OracleId, &key.LanguageId,
+			&outcomes[0], &valid[0], &errorTexts[0],
+			&outcomes[1], &valid[1], &errorTexts[1],
+		)
+		if err != nil {
+			return nil, err
+		}
+		var verdicts [2]*Verdict
+		for i := range verdicts {
+			verdicts[i] = &Verdict{Prediction: key}
+			verdicts[i].Outcome = Outcome(outcomes[i].String)
+			verdicts[i].Error = errorTexts[i].String
+			if valid[i].Valid {
+				verdicts[i].Valid = &valid[i].Bool
+			}
+		}
+		result = append(result, &PredictionConflict{Existing: verdicts[0], Incoming: verdicts[1]})
+	}
+	return result, rows.Err()
+}
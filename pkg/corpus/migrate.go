@@ -0,0 +1,129 @@
+package corpus
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// migrations/<major>.<minor>.sql upgrades a corpus from the previous version
+// to <major>.<minor>. Every change to schema.sql needs a matching migration.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type Migration struct {
+	Major int
+	Minor int
+	Sql   string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%d.%d", m.Major, m.Minor)
+}
+
+func (m Migration) after(major int, minor int) bool {
+	return m.Major > major || (m.Major == major && m.Minor > minor)
+}
+
+// Migrations lists every known migration in the order they apply.
+func Migrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		var m Migration
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		if _, err := fmt.Sscanf(name, "%d.%d", &m.Major, &m.Minor); err != nil {
+			return nil, fmt.Errorf("malformed migration name %s: %w", entry.Name(), err)
+		}
+		data, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m.Sql = string(data)
+		result = append(result, m)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[j].after(result[i].Major, result[i].Minor) })
+	return result, nil
+}
+
+// ErrNeedsMigration means a corpus is older than this program expects; run
+// `corpus migrate` on it.
+var ErrNeedsMigration = errors.New("corpus needs migrating")
+
+// SchemaVersion reads the newest schema version recorded in the database.
+func SchemaVersion(ctx context.Context, db *sql.DB) (major int, minor int, err error) {
+	err = db.QueryRowContext(
+		ctx,
+		"select major, minor from schema_version order by major desc, minor desc limit 1",
+	).Scan(&major, &minor)
+	return major, minor, err
+}
+
+// CheckCompatibility accepts corpora with the same major version and at least
+// the minor version this program was written for, since minor versions only
+// add tables and columns.
+func CheckCompatibility(major int, minor int) error {
+	if major != MAJOR {
+		return fmt.Errorf("expected major version %d, got %d.%d", MAJOR, major, minor)
+	}
+	if minor < MINOR {
+		return fmt.Errorf("%w: expected version %d.%d or newer, got %d.%d", ErrNeedsMigration, MAJOR, MINOR, major, minor)
+	}
+	return nil
+}
+
+// Migrate upgrades the database in place to the newest version this program
+// knows about, one migration per transaction. It returns the migrations it
+// applied.
+func Migrate(ctx context.Context, db *sql.DB) (applied []Migration, err error) {
+	major, minor, err := SchemaVersion(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if major != MAJOR {
+		return nil, fmt.Errorf("can't migrate from version %d.%d to %d.%d", major, minor, MAJOR, MINOR)
+	}
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	for _, migration := range migrations {
+		if migration.Major != major || !migration.after(major, minor) {
+			continue
+		}
+		if err := apply(ctx, db, migration); err != nil {
+			return applied, fmt.Errorf("migrating to %s: %w", migration, err)
+		}
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+func apply(ctx context.Context, db *sql.DB, migration Migration) error {
+	txn, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+	if _, err := txn.ExecContext(ctx, migration.Sql); err != nil {
+		return err
+	}
+	_, err = txn.ExecContext(
+		ctx,
+		"INSERT INTO schema_version (major, minor) VALUES (?, ?)",
+		migration.Major, migration.Minor,
+	)
+	if err != nil {
+		return err
+	}
+	return txn.Commit()
+}
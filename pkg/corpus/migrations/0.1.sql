@@ -0,0 +1,3 @@
+-- grade predictions with an explicit outcome. Existing predictions keep a null
+-- outcome, since their `valid` column can't tell e.g. timeouts from errors.
+ALTER TABLE predictions ADD COLUMN outcome TEXT;
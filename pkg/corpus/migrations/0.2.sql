@@ -0,0 +1,7 @@
+-- record which engine build and configuration each oracle consulted
+CREATE TABLE oracle_metadata(
+    oracle_id INTEGER REFERENCES oracles(id)
+  , "key" TEXT
+  , "value" TEXT
+  , CONSTRAINT oracle_metadata_pkey PRIMARY KEY (oracle_id, "key")
+);
@@ -0,0 +1,12 @@
+-- keep conflicting predictions found while merging corpora
+CREATE TABLE prediction_conflicts(
+    statement_id INTEGER REFERENCES statements(id)
+  , oracle_id INTEGER REFERENCES oracles(id)
+  , language_id INTEGER REFERENCES languages(id)
+  , error TEXT
+  , "message" TEXT
+  , valid BOOLEAN
+  , outcome TEXT
+  , source TEXT -- the path of the corpus the prediction was merged from
+);
+CREATE INDEX prediction_conflicts_by_prediction ON prediction_conflicts(statement_id, oracle_id, language_id);
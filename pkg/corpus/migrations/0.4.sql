@@ -0,0 +1,3 @@
+-- mark predictions copied from another statement with the same fingerprint
+ALTER TABLE predictions ADD COLUMN inferred_from INTEGER REFERENCES statements(id);
+ALTER TABLE prediction_conflicts ADD COLUMN inferred_from INTEGER REFERENCES statements(id);
@@ -0,0 +1,11 @@
+-- link statements to the statements they were made from, e.g. minimized by
+-- `predict minimize`
+CREATE TABLE derived_statements(
+    statement_id INTEGER REFERENCES statements(id)
+  , parent_id INTEGER REFERENCES statements(id)
+  , derivation TEXT -- how the statement was made from its parent, e.g. 'minimized'
+  , oracle_id INTEGER REFERENCES oracles(id) -- the oracle whose verdict the
+                                            -- derivation kept, if any
+  , CONSTRAINT derived_statements_pkey PRIMARY KEY (statement_id, parent_id, derivation, oracle_id)
+);
+CREATE INDEX derived_statements_by_parent ON derived_statements(parent_id, statement_id);
@@ -0,0 +1,13 @@
+-- the tokens pg_query.Scan finds in each pgsql statement, as recorded by
+-- `corpus tokens`
+CREATE TABLE statement_tokens(
+    statement_id INTEGER REFERENCES statements(id)
+  , ordinal INTEGER -- the token's position in the statement, counting from 0
+  , token TEXT -- pg_query's name for the token, e.g. 'SELECT', 'IDENT', or
+               -- 'ASCII_40' for '('
+  , keyword_kind TEXT -- e.g. 'RESERVED_KEYWORD'. Null unless the token is a keyword
+  , start_offset INTEGER -- in bytes from the start of the statement's text
+  , end_offset INTEGER
+  , CONSTRAINT statement_tokens_pkey PRIMARY KEY (statement_id, ordinal)
+);
+CREATE INDEX statement_tokens_by_token ON statement_tokens(token, statement_id);
@@ -0,0 +1,20 @@
+-- which grammar constructs each pgsql statement uses, as indexed from its
+-- pg_query parse tree by `corpus nodes`
+CREATE TABLE statement_nodes(
+    statement_id INTEGER REFERENCES statements(id)
+  , node TEXT -- a parse tree node type, e.g. 'SelectStmt' or 'WindowDef'
+  , top_level BOOLEAN -- whether the node is one of the statement's top-level
+                      -- statements, i.e. what the statement is
+  , "count" INTEGER -- how many of the node the parse tree has
+  , CONSTRAINT statement_nodes_pkey PRIMARY KEY (statement_id, node)
+);
+CREATE INDEX statement_nodes_by_node ON statement_nodes(node, top_level, statement_id);
+
+CREATE TABLE statement_options(
+    statement_id INTEGER REFERENCES statements(id)
+  , "option" TEXT -- a set boolean field, e.g. 'RangeSubselect.lateral', a
+                  -- non-default enum field, e.g. 'AlterTableCmd.subtype=AT_AddColumn',
+                  -- or an operator, e.g. 'A_Expr.name=->>'
+  , CONSTRAINT statement_options_pkey PRIMARY KEY (statement_id, "option")
+);
+CREATE INDEX statement_options_by_option ON statement_options("option", statement_id);
@@ -0,0 +1,5 @@
+-- languages carry their family and version, so that new dialects can be added
+-- with `corpus languages add`
+ALTER TABLE languages ADD COLUMN family TEXT;
+ALTER TABLE languages ADD COLUMN "version" TEXT;
+UPDATE languages SET family = 'postgres' WHERE id BETWEEN 0 AND 6;
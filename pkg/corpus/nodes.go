@@ -0,0 +1,205 @@
+package corpus
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v2"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// a NodeIndex records which grammar constructs a statement uses.
+type NodeIndex struct {
+	// how many of each type of node the parse tree has, e.g. "SelectStmt"
+	Nodes map[string]int
+	// the types of the top-level statements, i.e. what the statement is
+	TopLevel map[string]bool
+	// notable options: boolean fields that are set, e.g.
+	// "RangeSubselect.lateral", enum fields that aren't the default, e.g.
+	// "AlterTableCmd.subtype=AT_AddColumn", and operators, e.g. "A_Expr.name=->>".
+	// See defaultEnums and defaultBools for what counts as the default.
+	Options map[string]bool
+}
+
+// enums whose first value, Postgres' zero value, means the option wasn't given,
+// e.g. SETOP_NONE or DROP_RESTRICT. Other enums' first values are choices like
+// any other, e.g. AT_AddColumn or JOIN_INNER. Protobuf's own zero value, e.g.
+// SET_OPERATION_UNDEFINED, is never set.
+var defaultEnums = map[protoreflect.Name]bool{
+	"A_Expr_Kind":           true, // AEXPR_OP; the operator itself is recorded
+	"CTEMaterialize":        true,
+	"CmdType":               true,
+	"CoercionForm":          true,
+	"DefElemAction":         true,
+	"DropBehavior":          true,
+	"FunctionParameterMode": true,
+	"GrantTargetType":       true,
+	"LimitOption":           true,
+	"LockClauseStrength":    true,
+	"LockWaitPolicy":        true,
+	"OnCommitAction":        true,
+	"OnConflictAction":      true,
+	"OverridingKind":        true,
+	"QuerySource":           true,
+	"RoleSpecType":          true,
+	"SetOperation":          true,
+	"SortByDir":             true,
+	"SortByNulls":           true,
+	"ViewCheckOption":       true,
+}
+
+// boolean fields that the parser sets on nearly every node of their type
+var defaultBools = map[string]bool{
+	"ColumnDef.is_local": true,
+	"RangeVar.inh":       true, // unless the statement says ONLY
+}
+
+// IndexNodes walks the statement's pg_query parse tree.
+func IndexNodes(text string) (*NodeIndex, error) {
+	tree, err := pg_query.Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	index := NodeIndex{Nodes: map[string]int{}, TopLevel: map[string]bool{}, Options: map[string]bool{}}
+	for _, raw := range tree.Stmts {
+		node := raw.GetStmt()
+		if node == nil {
+			continue
+		}
+		message := node.ProtoReflect()
+		if field := message.WhichOneof(message.Descriptor().Oneofs().ByName("node")); field != nil {
+			index.TopLevel[string(field.Message().Name())] = true
+		}
+		index.walk(message)
+	}
+	return &index, nil
+}
+
+func (index *NodeIndex) walk(message protoreflect.Message) {
+	name := string(message.Descriptor().Name())
+	if name != "Node" { // the wrapper around every other node type
+		index.Nodes[name]++
+	}
+	if expr, ok := message.Interface().(*pg_query.A_Expr); ok {
+		for _, operator := range expr.GetName() {
+			if s := operator.GetString_(); s != nil {
+				index.Options["A_Expr.name="+s.GetStr()] = true
+			}
+		}
+	}
+	// only fields that are set, i.e. not false, zero, or empty
+	message.Range(func(field protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		switch {
+		case field.IsList() && field.Kind() == protoreflect.MessageKind:
+			list := value.List()
+			for i := 0; i < list.Len(); i++ {
+				index.walk(list.Get(i).Message())
+			}
+		case field.Kind() == protoreflect.MessageKind:
+			index.walk(value.Message())
+		case field.Kind() == protoreflect.BoolKind:
+			if option := fmt.Sprintf("%s.%s", name, field.Name()); !defaultBools[option] {
+				index.Options[option] = true
+			}
+		case field.Kind() == protoreflect.EnumKind:
+			if value.Enum() == 1 && defaultEnums[field.Enum().Name()] {
+				break
+			}
+			if enum := field.Enum().Values().ByNumber(value.Enum()); enum != nil {
+				index.Options[fmt.Sprintf("%s.%s=%s", name, field.Name(), enum.Name())] = true
+			}
+		}
+		return true
+	})
+}
+
+// a ConstructFilter narrows statements down by what IndexNodes found in them,
+// as recorded by BackfillNodes. Statements that haven't been indexed don't
+// match unless the filter is empty.
+type ConstructFilter struct {
+	// only statements whose top-level statement is one of these node types,
+	// e.g. "AlterTableStmt"
+	Kinds []string
+	// only statements using every one of these node types or options, e.g.
+	// "WindowDef" or "RangeSubselect.lateral"
+	Constructs []string
+}
+
+// params binds the :kinds, :constructs, and :n_constructs parameters.
+func (filter ConstructFilter) params() []interface{} {
+	lines := func(values []string) interface{} {
+		if len(values) == 0 {
+			return nil
+		}
+		return strings.Join(values, "\n")
+	}
+	constructs := map[string]bool{}
+	for _, construct := range filter.Constructs {
+		constructs[construct] = true
+	}
+	return []interface{}{
+		sql.Named("kinds", lines(filter.Kinds)),
+		sql.Named("constructs", lines(filter.Constructs)),
+		sql.Named("n_constructs", len(constructs)),
+	}
+}
+
+// pruneDefaultOptions deletes the default options that earlier versions of
+// IndexNodes recorded.
+func pruneDefaultOptions(ctx context.Context, db *sql.DB) error {
+	var patterns []interface{}
+	enums := pg_query.File_pg_query_proto.Enums()
+	for name := range defaultEnums {
+		if value := enums.ByName(name).Values().ByNumber(1); value != nil {
+			patterns = append(patterns, "*="+string(value.Name()))
+		}
+	}
+	for option := range defaultBools {
+		patterns = append(patterns, option)
+	}
+	query := `DELETE FROM statement_options WHERE "option" GLOB ?` +
+		strings.Repeat(` OR "option" GLOB ?`, len(patterns)-1)
+	_, err := db.ExecContext(ctx, query, patterns...)
+	return err
+}
+
+// BackfillNodes indexes the parse trees of the pgsql statements that aren't
+// indexed yet into statement_nodes and statement_options, after dropping the
+// default options that earlier versions recorded. It reports how many
+// statements it indexed and how many pg_query couldn't parse.
+func BackfillNodes(ctx context.Context, db *sql.DB) (indexed int64, unparseable int64, err error) {
+	if err := pruneDefaultOptions(ctx, db); err != nil {
+		return 0, 0, err
+	}
+	err = backfill(ctx, db, "statement_nodes", func(txn *sql.Tx, statement *Statement) error {
+		index, err := IndexNodes(statement.Text)
+		if err != nil {
+			unparseable++
+			return nil
+		}
+		for node, count := range index.Nodes {
+			_, err := txn.ExecContext(ctx,
+				`INSERT INTO statement_nodes (statement_id, node, top_level, "count") VALUES (?, ?, ?, ?)`+
+					" ON CONFLICT DO NOTHING",
+				statement.Id, node, index.TopLevel[node], count,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		for option := range index.Options {
+			_, err := txn.ExecContext(ctx,
+				`INSERT INTO statement_options (statement_id, "option") VALUES (?, ?) ON CONFLICT DO NOTHING`,
+				statement.Id, option,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		indexed++
+		return nil
+	})
+	return indexed, unparseable, err
+}
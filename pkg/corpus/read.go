@@ -1,55 +1,291 @@
 package corpus
 
 import (
+	"context"
 	"database/sql"
 	_ "embed"
-	"log"
+	"math"
+	"regexp"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-//go:embed sql/get_statements_by_language.sql
-var getStatementsByLanguage string
-
 type Statement struct {
 	Id   int64
 	Text string
 }
 
-func GetAllStatementsByLanguage(db *sql.DB, languageId int) []*Statement {
-	rows, err := db.Query(getStatementsByLanguage, languageId)
-	results := []*Statement{}
+// a StatementFilter narrows down which statements to read. Nil fields match
+// every statement.
+type StatementFilter struct {
+	// only statements tagged with this language
+	LanguageId *int64
+	// only statements this oracle hasn't predicted yet (in LanguageId, if set)
+	// or whose predictions timed out
+	UnpredictedBy *int64
+	// only statements found in this document
+	DocumentId *int64
+	// only statements with ids in [MinId, MaxId]
+	MinId *int64
+	MaxId *int64
+	// only one statement (in LanguageId, if set) per fingerprint; statements
+	// without fingerprints represent themselves. See PropagatePredictions.
+	Representatives bool
+}
+
+//go:embed sql/select_statements.sql
+var selectStatementsQuery string
+
+// the default number of statements read per query
+const DefaultPageSize = 1000
+
+func (filter *StatementFilter) params(fromId int64, limit int) []interface{} {
+	toId := int64(math.MaxInt64)
+	if filter.MaxId != nil {
+		toId = *filter.MaxId
+	}
+	if filter.MinId != nil && *filter.MinId > fromId {
+		fromId = *filter.MinId
+	}
+	var representatives interface{}
+	if filter.Representatives {
+		representatives = true
+	}
+	return []interface{}{
+		sql.Named("from_id", fromId),
+		sql.Named("to_id", toId),
+		sql.Named("language_id", filter.LanguageId),
+		sql.Named("document_id", filter.DocumentId),
+		sql.Named("oracle_id", filter.UnpredictedBy),
+		sql.Named("representatives", representatives),
+		sql.Named("limit", limit),
+	}
+}
+
+// CountStatements counts the statements matching the filter.
+func CountStatements(ctx context.Context, db *sql.DB, filter StatementFilter) (count int64, err error) {
+	query := "SELECT count(*) FROM (" + selectStatementsQuery + ")"
+	err = db.QueryRowContext(ctx, query, filter.params(math.MinInt64, -1)...).Scan(&count)
+	return count, err
+}
+
+// a StatementIterator streams statements matching a filter in order of id,
+// reading one page at a time so that memory use doesn't grow with the corpus.
+// Use it like sql.Rows:
+//
+//	statements := corpus.IterateStatements(ctx, db, filter)
+//	defer statements.Close()
+//	for statements.Next() {
+//		statement := statements.Statement()
+//	}
+//	if err := statements.Err(); err != nil { ... }
+//
+// Each page is its own query, so statements can be written to the database
+// between pages without holding a read transaction open for the whole scan.
+type StatementIterator struct {
+	ctx      context.Context
+	db       *sql.DB
+	filter   StatementFilter
+	PageSize int
+
+	page    []*Statement
+	current *Statement
+	nextId  int64
+	done    bool
+	err     error
+}
+
+func IterateStatements(ctx context.Context, db *sql.DB, filter StatementFilter) *StatementIterator {
+	return &StatementIterator{
+		ctx:      ctx,
+		db:       db,
+		filter:   filter,
+		PageSize: DefaultPageSize,
+		nextId:   math.MinInt64,
+	}
+}
+
+func (it *StatementIterator) fetch() error {
+	rows, err := it.db.QueryContext(it.ctx, selectStatementsQuery, it.filter.params(it.nextId, it.PageSize)...)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 	defer rows.Close()
 	for rows.Next() {
 		var row Statement
 		if err := rows.Scan(&row.Id, &row.Text); err != nil {
-			panic(err)
+			return err
+		}
+		it.page = append(it.page, &row)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(it.page) < it.PageSize {
+		it.done = true // a short page is the last page
+	} else if last := it.page[len(it.page)-1].Id; last == math.MaxInt64 {
+		it.done = true
+	} else {
+		it.nextId = last + 1
+	}
+	return nil
+}
+
+// Next advances to the next statement, returning false once there are none
+// left or an error occurred.
+func (it *StatementIterator) Next() bool {
+	it.current = nil
+	if it.err != nil {
+		return false
+	}
+	if len(it.page) == 0 {
+		if it.done {
+			return false
+		}
+		if it.err = it.fetch(); it.err != nil {
+			return false
+		}
+		if len(it.page) == 0 {
+			return false
+		}
+	}
+	it.current, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Statement returns the statement Next advanced to.
+func (it *StatementIterator) Statement() *Statement {
+	return it.current
+}
+
+// Err returns the error, if any, that stopped the iteration.
+func (it *StatementIterator) Err() error {
+	return it.err
+}
+
+// Close stops the iteration early. It's safe to call more than once.
+func (it *StatementIterator) Close() error {
+	it.done = true
+	it.page = nil
+	it.current = nil
+	return nil
+}
+
+// an OracleInfo describes an oracle recorded in the corpus.
+type OracleInfo struct {
+	Id       int64
+	Name     string
+	Metadata OracleMetadata
+}
+
+var leadingVersion = regexp.MustCompile(`\b(\d+)(?:\.\d+|\.X)?\b`)
+
+// Version reports the major server version the oracle emulates. Oracles
+// registered before 0.2 have no metadata, so fall back to the version in
+// their names, e.g. "postgres 13 raw driver".
+func (oracle *OracleInfo) Version() string {
+	if version, ok := oracle.Metadata["version"]; ok {
+		return version
+	}
+	if match := leadingVersion.FindStringSubmatch(oracle.Name); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// GetOracles lists every oracle in the corpus along with its metadata.
+func GetOracles(ctx context.Context, db *sql.DB) (map[int64]*OracleInfo, error) {
+	return getOracles(ctx, db, "main")
+}
+
+// a queryer is a *sql.DB, *sql.Conn, or *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// getOracles reads the oracles from the named schema, e.g. an attached corpus.
+func getOracles(ctx context.Context, db queryer, schema string) (map[int64]*OracleInfo, error) {
+	result := map[int64]*OracleInfo{}
+	rows, err := db.QueryContext(ctx, "SELECT id, name FROM "+schema+".oracles")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		oracle := OracleInfo{Metadata: OracleMetadata{}}
+		if err := rows.Scan(&oracle.Id, &oracle.Name); err != nil {
+			return nil, err
+		}
+		result[oracle.Id] = &oracle
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows, err = db.QueryContext(ctx, "SELECT oracle_id, key, value FROM "+schema+".oracle_metadata")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var key, value string
+		if err := rows.Scan(&id, &key, &value); err != nil {
+			return nil, err
+		}
+		if oracle, ok := result[id]; ok {
+			oracle.Metadata[key] = value
 		}
-		results = append(results, &row)
 	}
+	return result, rows.Err()
+}
 
-	return results
+// a ContestedPrediction is a prediction about a statement that another oracle
+// predicted differently, along with the statement and where it was found.
+type ContestedPrediction struct {
+	Prediction
+	Valid bool
+	Text  string
+	Urls  []string
 }
 
-//go:embed sql/get_unpredicted_statements.sql
-var getUnpredictedStatementsQuery string
+//go:embed sql/get_contested_predictions.sql
+var getContestedPredictionsQuery string
 
-func GetAllUnpredictedStatements(db *sql.DB, languageId int64, oracleId int64) []*Statement {
-	rows, err := db.Query(getUnpredictedStatementsQuery, languageId, oracleId, oracleId)
+// EachContestedPrediction streams the predictions on statements that oracles
+// disagree about, in order of statement then language, optionally limited to
+// one language and to statements matching the construct filter.
+func EachContestedPrediction(
+	ctx context.Context,
+	db *sql.DB,
+	languageId *int64,
+	constructs ConstructFilter,
+	fn func(*ContestedPrediction) error,
+) error {
+	params := append([]interface{}{sql.Named("language_id", languageId)}, constructs.params()...)
+	rows, err := db.QueryContext(ctx, getContestedPredictionsQuery, params...)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 	defer rows.Close()
-	results := []*Statement{}
 	for rows.Next() {
-		var row Statement
-		if err := rows.Scan(&row.Id, &row.Text); err != nil {
-			panic(err)
+		var row ContestedPrediction
+		var outcome, errorText, urls sql.NullString
+		err := rows.Scan(
+			&row.StatementId, &row.LanguageId, &row.OracleId,
+			&row.Valid, &outcome, &errorText, &row.Text, &urls,
+		)
+		if err != nil {
+			return err
+		}
+		row.Outcome = Outcome(outcome.String)
+		row.Error = errorText.String
+		if urls.Valid {
+			row.Urls = strings.Split(urls.String, "\n")
+		}
+		if err := fn(&row); err != nil {
+			return err
 		}
-		results = append(results, &row)
 	}
-	return results
+	return rows.Err()
 }
@@ -0,0 +1,30 @@
+-- the first :examples statements, by id, for each way the validity of a pair
+-- of matching oracles' predictions changed. See diff_predictions.sql.
+WITH changed AS (
+  SELECT
+      pair.old_id
+    , pair.new_id
+    , now.statement_id
+    , CASE WHEN was.valid IS NULL THEN 'unknown' WHEN was.valid THEN 'valid' ELSE 'invalid' END AS was
+    , CASE WHEN now.valid IS NULL THEN 'unknown' WHEN now.valid THEN 'valid' ELSE 'invalid' END AS now
+    , was.error AS old_error
+    , now.error AS new_error
+  FROM temp.oracle_pairs   AS pair
+  JOIN main.predictions    AS now ON now.oracle_id = pair.new_id
+  JOIN old.predictions     AS was
+    ON  was.oracle_id = pair.old_id
+    AND was.statement_id = now.statement_id
+    AND was.language_id = now.language_id
+  WHERE was.valid IS NOT now.valid
+), numbered AS (
+  SELECT
+      changed.*
+    , row_number() OVER (PARTITION BY old_id, new_id, was, now ORDER BY statement_id) AS n
+  FROM changed
+)
+SELECT numbered.old_id, numbered.new_id, numbered.was, numbered.now,
+       numbered.statement_id, stmt.text, numbered.old_error, numbered.new_error
+FROM numbered
+JOIN main.statements AS stmt ON stmt.id = numbered.statement_id
+WHERE :examples <= 0 OR n <= :examples
+ORDER BY numbered.old_id, numbered.new_id, numbered.was, numbered.now, n;
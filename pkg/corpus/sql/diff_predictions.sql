@@ -0,0 +1,20 @@
+-- the predictions of each pair of matching oracles on the same statement and
+-- language in the attached older corpus ("old") and this one, grouped by how
+-- their validity changed
+WITH compared AS (
+  SELECT
+      pair.old_id
+    , pair.new_id
+    , now.statement_id
+    , CASE WHEN was.valid IS NULL THEN 'unknown' WHEN was.valid THEN 'valid' ELSE 'invalid' END AS was
+    , CASE WHEN now.valid IS NULL THEN 'unknown' WHEN now.valid THEN 'valid' ELSE 'invalid' END AS now
+  FROM temp.oracle_pairs   AS pair
+  JOIN main.predictions    AS now ON now.oracle_id = pair.new_id
+  JOIN old.predictions     AS was
+    ON  was.oracle_id = pair.old_id
+    AND was.statement_id = now.statement_id
+    AND was.language_id = now.language_id
+)
+SELECT old_id, new_id, was, now, count(*)
+FROM compared
+GROUP BY old_id, new_id, was, now;
@@ -0,0 +1,73 @@
+-- every statement in each of its languages, once per prediction (or once with
+-- null prediction columns if it has none), along with where it came from
+SELECT
+    stmt.id
+  , stmt.text
+  , lang.name AS language_name
+  , prediction.oracle_id
+  , prediction.outcome
+  , prediction.valid
+  , prediction.error
+  , (
+      SELECT group_concat(url, char(10)) FROM (
+        SELECT DISTINCT urls.url
+        FROM document_statements AS src
+        JOIN document_urls       AS doc_url ON src.document_id = doc_url.document_id
+        JOIN urls                           ON doc_url.url_id = urls.id
+        WHERE src.statement_id = stmt.id
+        ORDER BY urls.url
+      )
+    ) AS urls
+  , (
+      SELECT group_concat(license_id, char(10)) FROM (
+        SELECT DISTINCT urls.license_id
+        FROM document_statements AS src
+        JOIN document_urls       AS doc_url ON src.document_id = doc_url.document_id
+        JOIN urls                           ON doc_url.url_id = urls.id
+        WHERE src.statement_id = stmt.id AND urls.license_id IS NOT NULL
+        ORDER BY urls.license_id
+      )
+    ) AS licenses
+FROM statement_languages AS stmt_lang
+JOIN statements          AS stmt       ON stmt.id = stmt_lang.statement_id
+LEFT JOIN languages      AS lang       ON lang.id = stmt_lang.language_id
+LEFT JOIN predictions    AS prediction
+  ON  prediction.statement_id = stmt_lang.statement_id
+  AND prediction.language_id = stmt_lang.language_id
+WHERE (:language_id IS NULL OR stmt_lang.language_id = :language_id)
+  AND (
+    :validity IS NULL
+    OR (:validity = 'valid'   AND prediction.valid = 1)
+    OR (:validity = 'invalid' AND prediction.valid = 0)
+    OR (:validity = 'unknown' AND prediction.statement_id IS NOT NULL AND prediction.valid IS NULL)
+  )
+  AND (
+    :license_id IS NULL
+    OR EXISTS (
+      SELECT 1
+      FROM document_statements AS src
+      JOIN document_urls       AS doc_url ON src.document_id = doc_url.document_id
+      JOIN urls                           ON doc_url.url_id = urls.id
+      WHERE src.statement_id = stmt.id AND urls.license_id = :license_id
+    )
+  )
+  AND (
+    :kinds IS NULL
+    OR EXISTS (
+      SELECT 1 FROM statement_nodes AS node
+      WHERE node.statement_id = stmt.id AND node.top_level
+        AND instr(char(10) || :kinds || char(10), char(10) || node.node || char(10)) > 0
+    )
+  )
+  AND (
+    :constructs IS NULL
+    OR :n_constructs = (
+      SELECT count(*) FROM (
+        SELECT node AS construct FROM statement_nodes WHERE statement_id = stmt.id
+        UNION
+        SELECT "option" FROM statement_options WHERE statement_id = stmt.id
+      )
+      WHERE instr(char(10) || :constructs || char(10), char(10) || construct || char(10)) > 0
+    )
+  )
+ORDER BY stmt.id, stmt_lang.language_id, prediction.oracle_id;
@@ -0,0 +1,51 @@
+-- for each version and each node type or keyword in the language's statements
+-- that the version's oracles graded, whether the version accepts any of those
+-- statements. Only statements matching the optional :kinds and :constructs
+-- filters are included.
+WITH statement_verdicts AS (
+  SELECT
+      prediction.statement_id
+    , oracle_version.version
+    , min(prediction.valid) AS accepted
+  FROM predictions AS prediction
+  JOIN temp.coverage_versions AS oracle_version
+    ON prediction.oracle_id = oracle_version.oracle_id
+  JOIN statement_languages AS stmt_lang
+    ON  stmt_lang.statement_id = prediction.statement_id
+    AND stmt_lang.language_id = prediction.language_id
+  WHERE prediction.language_id = :language_id
+    AND prediction.valid IS NOT NULL
+    AND (
+      :kinds IS NULL
+      OR EXISTS (
+        SELECT 1 FROM statement_nodes AS node
+        WHERE node.statement_id = prediction.statement_id AND node.top_level
+          AND instr(char(10) || :kinds || char(10), char(10) || node.node || char(10)) > 0
+      )
+    )
+    AND (
+      :constructs IS NULL
+      OR :n_constructs = (
+        SELECT count(*) FROM (
+          SELECT node AS construct FROM statement_nodes WHERE statement_id = prediction.statement_id
+          UNION
+          SELECT "option" FROM statement_options WHERE statement_id = prediction.statement_id
+        )
+        WHERE instr(char(10) || :constructs || char(10), char(10) || construct || char(10)) > 0
+      )
+    )
+  GROUP BY prediction.statement_id, oracle_version.version
+), constructs AS (
+  SELECT statement_id, 'node' AS kind, node AS construct FROM statement_nodes
+  UNION
+  SELECT statement_id, 'keyword', token FROM statement_tokens WHERE keyword_kind IS NOT NULL
+)
+SELECT
+    verdict.version
+  , construct.kind
+  , construct.construct
+  , max(verdict.accepted)
+FROM statement_verdicts AS verdict
+JOIN constructs AS construct ON construct.statement_id = verdict.statement_id
+GROUP BY verdict.version, construct.kind, construct.construct
+ORDER BY verdict.version, construct.kind, construct.construct;
@@ -0,0 +1,55 @@
+-- every prediction that grades a statement's validity, for each statement and
+-- language that the oracles don't agree on. Oracles for different versions may
+-- disagree legitimately, so callers still need to compare them by version.
+WITH contested AS (
+  SELECT statement_id, language_id
+  FROM predictions
+  WHERE valid IS NOT NULL
+    AND (:language_id IS NULL OR language_id = :language_id)
+    AND (
+      :kinds IS NULL
+      OR EXISTS (
+        SELECT 1 FROM statement_nodes AS node
+        WHERE node.statement_id = predictions.statement_id AND node.top_level
+          AND instr(char(10) || :kinds || char(10), char(10) || node.node || char(10)) > 0
+      )
+    )
+    AND (
+      :constructs IS NULL
+      OR :n_constructs = (
+        SELECT count(*) FROM (
+          SELECT node AS construct FROM statement_nodes WHERE statement_id = predictions.statement_id
+          UNION
+          SELECT "option" FROM statement_options WHERE statement_id = predictions.statement_id
+        )
+        WHERE instr(char(10) || :constructs || char(10), char(10) || construct || char(10)) > 0
+      )
+    )
+  GROUP BY statement_id, language_id
+  HAVING min(valid) != max(valid)
+)
+SELECT
+    prediction.statement_id
+  , prediction.language_id
+  , prediction.oracle_id
+  , prediction.valid
+  , prediction.outcome
+  , prediction.error
+  , stmt.text
+  , (
+      SELECT group_concat(url, char(10)) FROM (
+        SELECT DISTINCT urls.url
+        FROM document_statements AS src
+        JOIN document_urls       AS doc_url ON src.document_id = doc_url.document_id
+        JOIN urls                           ON doc_url.url_id = urls.id
+        WHERE src.statement_id = prediction.statement_id
+        ORDER BY urls.url
+      )
+    ) AS urls
+FROM contested
+JOIN predictions AS prediction
+  ON  prediction.statement_id = contested.statement_id
+  AND prediction.language_id = contested.language_id
+JOIN statements AS stmt ON stmt.id = prediction.statement_id
+WHERE prediction.valid IS NOT NULL
+ORDER BY prediction.statement_id, prediction.language_id, prediction.oracle_id;
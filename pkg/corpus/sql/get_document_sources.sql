@@ -0,0 +1,5 @@
+-- the urls each document was found at and their licenses, if known
+SELECT doc_url.document_id, urls.url, urls.license_id
+FROM document_urls AS doc_url
+JOIN urls ON urls.id = doc_url.url_id
+ORDER BY doc_url.document_id, urls.url;
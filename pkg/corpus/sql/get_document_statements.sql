@@ -0,0 +1,34 @@
+-- every statement in each document in the language, in the order they appear,
+-- once per prediction (or once with null prediction columns if it has none).
+-- Positions count every statement in the document, whatever its language.
+WITH positions AS (
+  SELECT
+      document_id
+    , statement_id
+    , start_offset
+    , start_line
+    , end_line
+    , row_number() OVER (PARTITION BY document_id ORDER BY start_offset) - 1 AS ordinal
+  FROM document_statements
+)
+SELECT
+    src.document_id
+  , src.statement_id
+  , src.ordinal
+  , src.start_offset
+  , src.start_line
+  , src.end_line
+  , stmt.text
+  , prediction.oracle_id
+  , prediction.outcome
+  , prediction.valid
+  , prediction.error
+FROM positions           AS src
+JOIN statements          AS stmt      ON stmt.id = src.statement_id
+JOIN statement_languages AS stmt_lang
+  ON  stmt_lang.statement_id = src.statement_id
+  AND stmt_lang.language_id = :language_id
+LEFT JOIN predictions    AS prediction
+  ON  prediction.statement_id = src.statement_id
+  AND prediction.language_id = :language_id
+ORDER BY src.document_id, src.start_offset, prediction.oracle_id;
@@ -3,6 +3,7 @@ SELECT
     lower(hex(stmt.id)) AS statement_id
   , lower(hex(fingerprint.fingerprint)) AS fingerprint
   , prediction.valid
+  , prediction.outcome
   , oracle.name AS oracle_name
   , stmt.text AS statement_text
   , lang.name AS language_name
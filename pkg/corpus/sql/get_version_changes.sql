@@ -0,0 +1,62 @@
+-- statements that some versions unanimously accept and others unanimously
+-- reject, with the first and last accepting versions' ordinals, the first
+-- rejecting version's, and the first rejecting version's after the last
+-- accepting version, if any. Only statements matching the optional :kinds and
+-- :constructs filters are included.
+WITH verdicts AS (
+  SELECT
+      prediction.statement_id
+    , oracle_version.ordinal
+    , min(prediction.valid) AS accepted
+  FROM predictions AS prediction
+  JOIN temp.oracle_versions AS oracle_version
+    ON prediction.oracle_id = oracle_version.oracle_id
+  WHERE prediction.language_id = :language_id
+    AND prediction.valid IS NOT NULL
+  GROUP BY prediction.statement_id, oracle_version.ordinal
+  HAVING min(prediction.valid) = max(prediction.valid)
+), spans AS (
+  SELECT
+      statement_id
+    , min(CASE WHEN accepted THEN ordinal END)     AS first_accepted
+    , max(CASE WHEN accepted THEN ordinal END)     AS last_accepted
+    , min(CASE WHEN NOT accepted THEN ordinal END) AS first_rejected
+  FROM verdicts
+  GROUP BY statement_id
+)
+SELECT
+    spans.statement_id
+  , stmt.text
+  , spans.first_accepted
+  , spans.last_accepted
+  , spans.first_rejected
+  , (
+      SELECT min(verdict.ordinal) FROM verdicts AS verdict
+      WHERE verdict.statement_id = spans.statement_id
+        AND NOT verdict.accepted
+        AND verdict.ordinal > spans.last_accepted
+    ) AS removed
+FROM spans
+JOIN statements AS stmt ON stmt.id = spans.statement_id
+WHERE spans.first_accepted IS NOT NULL
+  AND spans.first_rejected IS NOT NULL
+  AND (
+    :kinds IS NULL
+    OR EXISTS (
+      SELECT 1 FROM statement_nodes AS node
+      WHERE node.statement_id = spans.statement_id AND node.top_level
+        AND instr(char(10) || :kinds || char(10), char(10) || node.node || char(10)) > 0
+    )
+  )
+  AND (
+    :constructs IS NULL
+    OR :n_constructs = (
+      SELECT count(*) FROM (
+        SELECT node AS construct FROM statement_nodes WHERE statement_id = spans.statement_id
+        UNION
+        SELECT "option" FROM statement_options WHERE statement_id = spans.statement_id
+      )
+      WHERE instr(char(10) || :constructs || char(10), char(10) || construct || char(10)) > 0
+    )
+  )
+ORDER BY spans.statement_id;
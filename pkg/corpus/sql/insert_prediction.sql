@@ -5,6 +5,7 @@ INSERT INTO predictions (
   , "message"
   , "error"
   , valid
+  , outcome
 ) VALUES (
     ? -- 1: statement_id
   , ? -- 2: oracle_id
@@ -12,4 +13,13 @@ INSERT INTO predictions (
   , ? -- 4: message
   , ? -- 5: error
   , ? -- 6: whether the statement is explicitly valid/not
-) ON CONFLICT DO NOTHING;
\ No newline at end of file
+  , ? -- 7: outcome
+)
+-- replace timeouts, which are retried
+ON CONFLICT (statement_id, oracle_id, language_id) DO UPDATE SET
+    "message" = excluded."message"
+  , "error" = excluded."error"
+  , valid = excluded.valid
+  , outcome = excluded.outcome
+  , inferred_from = excluded.inferred_from
+WHERE predictions.outcome IS 'timeout';
\ No newline at end of file
@@ -0,0 +1,17 @@
+-- a version accepts a statement if every graded prediction made by that
+-- version's oracles says the statement is valid
+INSERT INTO statement_versions (statement_id, version_id)
+SELECT
+    prediction.statement_id
+  , oracle_version.version_id
+FROM predictions AS prediction
+JOIN temp.oracle_versions AS oracle_version
+  ON prediction.oracle_id = oracle_version.oracle_id
+JOIN statement_languages AS stmt_lang
+  ON  stmt_lang.statement_id = prediction.statement_id
+  AND stmt_lang.language_id = prediction.language_id
+WHERE prediction.language_id = :language_id
+  AND prediction.valid IS NOT NULL
+GROUP BY prediction.statement_id, oracle_version.version_id
+HAVING min(prediction.valid) = 1
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,40 @@
+-- copy an oracle's predictions in a language to the unpredicted statements in
+-- the language that share a fingerprint with a predicted statement
+INSERT INTO predictions (
+    statement_id
+  , oracle_id
+  , language_id
+  , "error"
+  , valid
+  , outcome
+  , inferred_from
+)
+SELECT
+    twin.statement_id
+  , prediction.oracle_id
+  , prediction.language_id
+  , prediction.error
+  , prediction.valid
+  , prediction.outcome
+  , prediction.statement_id
+FROM predictions                AS prediction
+JOIN statement_fingerprints     AS fingerprint
+  ON fingerprint.statement_id = prediction.statement_id
+JOIN statement_fingerprints     AS twin
+  ON  twin.fingerprint = fingerprint.fingerprint
+  AND twin.statement_id <> fingerprint.statement_id
+JOIN statement_languages        AS twin_lang
+  ON  twin_lang.statement_id = twin.statement_id
+  AND twin_lang.language_id = prediction.language_id
+WHERE prediction.oracle_id = :oracle_id
+  AND prediction.language_id = :language_id
+  AND prediction.inferred_from IS NULL
+ORDER BY prediction.statement_id
+-- replace timeouts, which are retried
+ON CONFLICT (statement_id, oracle_id, language_id) DO UPDATE SET
+    "message" = excluded."message"
+  , "error" = excluded."error"
+  , valid = excluded.valid
+  , outcome = excluded.outcome
+  , inferred_from = excluded.inferred_from
+WHERE predictions.outcome IS 'timeout';
@@ -0,0 +1,52 @@
+-- one page of statements matching the optional filters, in order of id.
+-- Each null filter matches everything.
+SELECT
+    stmt.id
+  , stmt.text
+FROM statements AS stmt
+WHERE stmt.id BETWEEN :from_id AND :to_id
+  AND (
+    :language_id IS NULL
+    OR EXISTS (
+      SELECT 1 FROM statement_languages AS stmt_lang
+      WHERE stmt_lang.language_id = :language_id
+        AND stmt_lang.statement_id = stmt.id
+    )
+  )
+  AND (
+    :document_id IS NULL
+    OR EXISTS (
+      SELECT 1 FROM document_statements AS src
+      WHERE src.document_id = :document_id
+        AND src.statement_id = stmt.id
+    )
+  )
+  AND (
+    :oracle_id IS NULL
+    OR NOT EXISTS (
+      SELECT 1 FROM predictions AS prediction
+      WHERE prediction.oracle_id = :oracle_id
+        AND prediction.statement_id = stmt.id
+        AND (:language_id IS NULL OR prediction.language_id = :language_id)
+        -- retry timeouts, e.g. from a loaded machine
+        AND prediction.outcome IS NOT 'timeout'
+    )
+  )
+  AND (
+    -- only the statement with the lowest id among those in the language that
+    -- share a fingerprint
+    :representatives IS NULL
+    OR NOT EXISTS (
+      SELECT 1
+      FROM statement_fingerprints AS fingerprint
+      JOIN statement_fingerprints AS twin
+        ON  twin.fingerprint = fingerprint.fingerprint
+        AND twin.statement_id < fingerprint.statement_id
+      JOIN statement_languages AS twin_lang
+        ON  twin_lang.statement_id = twin.statement_id
+        AND (:language_id IS NULL OR twin_lang.language_id = :language_id)
+      WHERE fingerprint.statement_id = stmt.id
+    )
+  )
+ORDER BY stmt.id
+LIMIT :limit
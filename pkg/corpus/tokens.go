@@ -0,0 +1,40 @@
+package corpus
+
+import (
+	"context"
+	"database/sql"
+
+	pg_query "github.com/pganalyze/pg_query_go/v2"
+)
+
+// BackfillTokens records the tokens pg_query.Scan finds in each pgsql
+// statement that has none recorded yet. It reports how many statements it
+// tokenized, how many tokens it recorded, and how many statements pg_query
+// couldn't scan, e.g. because of an unterminated string.
+func BackfillTokens(ctx context.Context, db *sql.DB) (statements int64, tokens int64, unscannable int64, err error) {
+	err = backfill(ctx, db, "statement_tokens", func(txn *sql.Tx, statement *Statement) error {
+		result, err := pg_query.Scan(statement.Text)
+		if err != nil {
+			unscannable++
+			return nil
+		}
+		for i, token := range result.Tokens {
+			var keywordKind interface{}
+			if token.KeywordKind != pg_query.KeywordKind_NO_KEYWORD {
+				keywordKind = token.KeywordKind.String()
+			}
+			_, err := txn.ExecContext(ctx,
+				"INSERT INTO statement_tokens (statement_id, ordinal, token, keyword_kind, start_offset, end_offset)"+
+					" VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING",
+				statement.Id, i, token.Token.String(), keywordKind, token.Start, token.End,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		statements++
+		tokens += int64(len(result.Tokens))
+		return nil
+	})
+	return statements, tokens, unscannable, err
+}
@@ -0,0 +1,191 @@
+package corpus
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// the only family of versions so far
+const PostgresFamily = "postgres"
+
+// DeriveVersionId hashes the family and version, e.g. ("postgres", "14").
+func DeriveVersionId(family string, version string) int64 {
+	return int64(xxhash.Sum64String(family + " " + version))
+}
+
+// CompareVersions orders dotted version numbers numerically, so that 9.6 < 10.
+// It returns -1, 0, or 1 like strings.Compare.
+func CompareVersions(a string, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, xErr := strconv.Atoi(as[i])
+		y, yErr := strconv.Atoi(bs[i])
+		if xErr != nil || yErr != nil {
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		} else if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// a VersionChange is a statement that some versions accept and others reject.
+// Introduced is the first accepting version if an earlier version rejects the
+// statement; Removed is the first rejecting version after the last accepting
+// one. Either may be empty.
+type VersionChange struct {
+	StatementId int64
+	Text        string
+	Earliest    string
+	Latest      string
+	Introduced  string
+	Removed     string
+}
+
+//go:embed sql/insert_statement_versions.sql
+var insertStatementVersionsQuery string
+
+//go:embed sql/get_version_changes.sql
+var getVersionChangesQuery string
+
+// DeriveStatementVersions records which of the family's versions accept each
+// statement in the language, judging by the oracles for each version. It
+// fills the versions and language_versions tables, replaces the language's
+// statements' rows in statement_versions, and returns the versions it found
+// in order along with every statement matching constructs whose acceptance
+// changes between them.
+func DeriveStatementVersions(
+	ctx context.Context,
+	db *sql.DB,
+	family string,
+	languageId int64,
+	constructs ConstructFilter,
+) (versions []string, changes []*VersionChange, err error) {
+	oracles, err := GetOracles(ctx, db)
+	if err != nil {
+		return nil, nil, err
+	}
+	seen := map[string]bool{}
+	for _, oracle := range oracles {
+		if version := oracle.Version(); version != "" && !seen[version] {
+			seen[version] = true
+			versions = append(versions, version)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return CompareVersions(versions[i], versions[j]) < 0 })
+	ordinals := make(map[string]int, len(versions))
+	for i, version := range versions {
+		ordinals[version] = i
+	}
+
+	// temp tables only exist on the connection that made them
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	txn, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer txn.Rollback()
+	steps := []string{
+		"DROP TABLE IF EXISTS temp.oracle_versions",
+		"CREATE TEMP TABLE oracle_versions (oracle_id INTEGER PRIMARY KEY, version_id INTEGER, ordinal INTEGER)",
+	}
+	for _, step := range steps {
+		if _, err := txn.ExecContext(ctx, step); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, version := range versions {
+		id := DeriveVersionId(family, version)
+		_, err := txn.ExecContext(ctx,
+			"INSERT INTO versions (id, family, version) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+			id, family, version)
+		if err != nil {
+			return nil, nil, err
+		}
+		_, err = txn.ExecContext(ctx,
+			"INSERT INTO language_versions (language_id, version_id) VALUES (?, ?) ON CONFLICT DO NOTHING",
+			languageId, id)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, oracle := range oracles {
+		version := oracle.Version()
+		if version == "" {
+			continue
+		}
+		_, err := txn.ExecContext(ctx,
+			"INSERT INTO temp.oracle_versions (oracle_id, version_id, ordinal) VALUES (?, ?, ?)",
+			oracle.Id, DeriveVersionId(family, version), ordinals[version])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	language := sql.Named("language_id", languageId)
+	_, err = txn.ExecContext(ctx, `
+		DELETE FROM statement_versions
+		WHERE version_id IN (SELECT version_id FROM temp.oracle_versions)
+		  AND statement_id IN (SELECT statement_id FROM statement_languages WHERE language_id = :language_id)`,
+		language)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := txn.ExecContext(ctx, insertStatementVersionsQuery, language); err != nil {
+		return nil, nil, err
+	}
+
+	params := append([]interface{}{language}, constructs.params()...)
+	rows, err := txn.QueryContext(ctx, getVersionChangesQuery, params...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var change VersionChange
+		var firstAccepted, lastAccepted, firstRejected int
+		var removed sql.NullInt64
+		err := rows.Scan(
+			&change.StatementId, &change.Text,
+			&firstAccepted, &lastAccepted, &firstRejected, &removed,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		change.Earliest, change.Latest = versions[firstAccepted], versions[lastAccepted]
+		if firstRejected < firstAccepted {
+			change.Introduced = change.Earliest
+		}
+		if removed.Valid {
+			change.Removed = versions[removed.Int64]
+		}
+		changes = append(changes, &change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if _, err := txn.ExecContext(ctx, "DROP TABLE temp.oracle_versions"); err != nil {
+		return nil, nil, err
+	}
+	return versions, changes, txn.Commit()
+}
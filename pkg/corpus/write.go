@@ -2,6 +2,8 @@ package corpus
 
 import (
 	"database/sql"
+	"sort"
+	"strings"
 
 	_ "embed"
 
@@ -9,24 +11,95 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// an Outcome grades what an oracle made of a statement.
+type Outcome string
+
+const (
+	// the statement ran without error
+	OutcomeValid Outcome = "valid"
+	// the statement couldn't be lexed or parsed
+	OutcomeSyntaxError Outcome = "syntax-error"
+	// the statement parsed, but e.g. referred to a nonexistent table
+	OutcomeSemanticError Outcome = "semantic-error"
+	// the statement parsed, but failed while running, e.g. division by zero
+	OutcomeRuntimeError Outcome = "runtime-error"
+	// the oracle worked, but its output can't be graded
+	OutcomeAmbiguous Outcome = "ambiguous"
+	// the oracle didn't reach a verdict before the deadline
+	OutcomeTimeout Outcome = "timeout"
+	// the oracle itself broke
+	OutcomeOracleFailure Outcome = "oracle-failure"
+	// the database server behind the oracle crashed or dropped the connection
+	OutcomeServerCrash Outcome = "server-crash"
+	// the statement parsed, but deparsing and re-parsing it lost or changed
+	// its meaning
+	OutcomeRoundTripMismatch Outcome = "round-trip-mismatch"
+)
+
+// Validity collapses an outcome into whether the statement's syntax is valid.
+// It returns nil when the outcome doesn't say either way.
+func (outcome Outcome) Validity() *bool {
+	var valid bool
+	switch outcome {
+	case OutcomeValid, OutcomeSemanticError, OutcomeRuntimeError, OutcomeRoundTripMismatch:
+		valid = true
+	case OutcomeSyntaxError:
+		valid = false
+	default:
+		return nil
+	}
+	return &valid
+}
+
 type Prediction struct {
 	StatementId int64
 	OracleId    int64
 	LanguageId  int64
-	// may be nil in case of ambiguous oracle output.
-	Valid   *bool
-	Message string
-	Error   string
+	Outcome     Outcome
+	Message     string
+	Error       string
+}
+
+// Valid is nil in case of ambiguous oracle output.
+func (prediction *Prediction) Valid() *bool {
+	return prediction.Outcome.Validity()
+}
+
+// OracleMetadata records exactly which build and configuration of an engine an
+// oracle consulted, e.g. {"server_version": "PostgreSQL 14.1 on x86_64-..."}.
+type OracleMetadata map[string]string
+
+// Fingerprint serializes the metadata as `key=value` lines in order of key.
+func (metadata OracleMetadata) Fingerprint() string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fingerprint := strings.Builder{}
+	for _, key := range keys {
+		fingerprint.WriteString(key)
+		fingerprint.WriteString("=")
+		fingerprint.WriteString(metadata[key])
+		fingerprint.WriteString("\n")
+	}
+	return fingerprint.String()
 }
 
-func DeriveOracleId(name string) int64 {
-	return int64(xxhash.Sum64([]byte(name)))
+// DeriveOracleId hashes the oracle's name together with its metadata so that
+// oracles backed by different engine builds or configurations never share an
+// id. Oracles without metadata keep the id of their name alone.
+func DeriveOracleId(name string, metadata OracleMetadata) int64 {
+	if len(metadata) == 0 {
+		return int64(xxhash.Sum64([]byte(name)))
+	}
+	return int64(xxhash.Sum64([]byte(name + "\n" + metadata.Fingerprint())))
 }
 
 func RegisterOracleName(db *sql.DB, oracleName string) (id int64, err error) {
-	id = DeriveOracleId(oracleName)
+	id = DeriveOracleId(oracleName, nil)
 	_, err = db.Exec(
-		"INSERT INTO oracles (id, name) VALUES (?, ?);",
+		"INSERT INTO oracles (id, name) VALUES (?, ?) ON CONFLICT DO NOTHING",
 		id, oracleName)
 	return id, err
 }
@@ -39,6 +112,34 @@ func RegisterOracleId(db *sql.DB, id int64, name string) error {
 	return err
 }
 
+// RegisterOracle records the oracle and its metadata, unless an oracle with the
+// same id is already present.
+func RegisterOracle(db *sql.DB, id int64, name string, metadata OracleMetadata) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = txn.Exec(
+		"INSERT INTO oracles(id, name) VALUES (?, ?) ON CONFLICT DO NOTHING",
+		id, name,
+	)
+	if err != nil {
+		_ = txn.Rollback()
+		return err
+	}
+	for key, value := range metadata {
+		_, err = txn.Exec(
+			"INSERT INTO oracle_metadata(oracle_id, key, value) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+			id, key, value,
+		)
+		if err != nil {
+			_ = txn.Rollback()
+			return err
+		}
+	}
+	return txn.Commit()
+}
+
 //go:embed sql/insert_prediction.sql
 var addPrediction string
 
@@ -50,8 +151,7 @@ func InsertPrediction(
 	_, err := db.Exec(
 		addPrediction,
 		prediction.StatementId, prediction.OracleId, prediction.LanguageId,
-		prediction.Message, prediction.Error, prediction.Valid)
+		prediction.Message, prediction.Error, prediction.Valid(),
+		prediction.Outcome)
 	return err
 }
-
-// func BulkInsertPredictions()
@@ -0,0 +1,188 @@
+package corpus
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sqlite allows at most 32766 parameters per statement, and each prediction
+// takes 7.
+const maxPredictionBatchSize = 32766 / 7
+
+type WriterOptions struct {
+	// how many predictions to insert per statement and transaction; defaults to
+	// 1000. Values above the 32766-parameter limit are clamped.
+	BatchSize int
+	// commit a partial batch once the oldest unsaved prediction is this old, so
+	// that slow oracles still save progress. 0 waits for full batches.
+	FlushInterval time.Duration
+}
+
+// a PredictionWriter saves predictions in batches, committing after each batch
+// so that a crash loses at most one batch. It holds its own connection, tuned
+// for bulk loading, until it's closed. It isn't safe for concurrent use.
+type PredictionWriter struct {
+	conn          *sql.Conn
+	batchSize     int
+	flushInterval time.Duration
+	insert        *sql.Stmt // reused for every full batch
+	batch         []*Prediction
+	oldest        time.Time
+	written       int64
+}
+
+func insertPredictionsSql(n int) string {
+	s := strings.Builder{}
+	s.WriteString("INSERT INTO predictions")
+	s.WriteString("(statement_id, oracle_id, language_id, message, error, valid, outcome)")
+	s.WriteString(" VALUES ")
+	for i := 0; i < n-1; i++ {
+		s.WriteString("(?,?,?,?,?,?,?),")
+	}
+	s.WriteString("(?,?,?,?,?,?,?)")
+	// replace timeouts, which are retried
+	s.WriteString(" ON CONFLICT (statement_id, oracle_id, language_id) DO UPDATE SET")
+	s.WriteString(" message = excluded.message, error = excluded.error, valid = excluded.valid,")
+	s.WriteString(" outcome = excluded.outcome, inferred_from = NULL")
+	s.WriteString(" WHERE predictions.outcome IS 'timeout'")
+	return s.String()
+}
+
+// NewPredictionWriter switches the database to write-ahead logging, which lets
+// statements be read while predictions are being written, and relaxes syncing
+// to once per checkpoint for the writer's connection. Write-ahead logging
+// persists in the database file; see RestoreJournalMode.
+func NewPredictionWriter(ctx context.Context, db *sql.DB, options WriterOptions) (*PredictionWriter, error) {
+	batchSize := options.BatchSize
+	if batchSize <= 0 {
+		batchSize = 1000
+	} else if batchSize > maxPredictionBatchSize {
+		batchSize = maxPredictionBatchSize
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, pragma := range []string{"PRAGMA journal_mode=WAL", "PRAGMA synchronous=NORMAL"} {
+		if _, err := conn.ExecContext(ctx, pragma); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	insert, err := conn.PrepareContext(ctx, insertPredictionsSql(batchSize))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	writer := PredictionWriter{
+		conn:          conn,
+		batchSize:     batchSize,
+		flushInterval: options.FlushInterval,
+		insert:        insert,
+		batch:         make([]*Prediction, 0, batchSize),
+	}
+	return &writer, nil
+}
+
+// Write queues the prediction, saving the queue if it's full or old enough.
+func (w *PredictionWriter) Write(ctx context.Context, prediction *Prediction) error {
+	if len(w.batch) == 0 {
+		w.oldest = time.Now()
+	}
+	w.batch = append(w.batch, prediction)
+	if len(w.batch) >= w.batchSize ||
+		(w.flushInterval > 0 && time.Since(w.oldest) >= w.flushInterval) {
+		return w.Flush(ctx)
+	}
+	return nil
+}
+
+// Flush saves and commits any queued predictions.
+func (w *PredictionWriter) Flush(ctx context.Context) error {
+	if len(w.batch) == 0 {
+		return nil
+	}
+	txn, err := w.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+	var insert *sql.Stmt
+	if len(w.batch) == w.batchSize {
+		insert = txn.StmtContext(ctx, w.insert)
+	} else {
+		insert, err = txn.PrepareContext(ctx, insertPredictionsSql(len(w.batch)))
+		if err != nil {
+			return err
+		}
+	}
+	defer insert.Close()
+	params := make([]interface{}, 0, 7*len(w.batch))
+	for _, prediction := range w.batch {
+		params = append(params,
+			prediction.StatementId,
+			prediction.OracleId,
+			prediction.LanguageId,
+			prediction.Message,
+			prediction.Error,
+			prediction.Valid(),
+			prediction.Outcome,
+		)
+	}
+	if _, err := insert.ExecContext(ctx, params...); err != nil {
+		return err
+	}
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+	w.written += int64(len(w.batch))
+	w.batch = w.batch[0:0]
+	return nil
+}
+
+// Written counts the predictions committed so far.
+func (w *PredictionWriter) Written() int64 {
+	return w.written
+}
+
+// Close saves any queued predictions and releases the writer's connection.
+func (w *PredictionWriter) Close(ctx context.Context) error {
+	err := w.Flush(ctx)
+	w.insert.Close()
+	if closeErr := w.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// JournalMode reads the database's journal mode, e.g. "delete" or "wal".
+func JournalMode(ctx context.Context, db *sql.DB) (mode string, err error) {
+	err = db.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&mode)
+	return mode, err
+}
+
+// RestoreJournalMode switches the corpus at path back to the journal mode it
+// had before a PredictionWriter switched it to write-ahead logging, which
+// checkpoints the log and removes the -wal and -shm files. Leaving write-ahead
+// logging needs every other connection to the database to be closed first.
+func RestoreJournalMode(ctx context.Context, path string, mode string) error {
+	if strings.EqualFold(mode, "wal") {
+		return nil
+	}
+	db, err := OpenExisting(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var restored string
+	if err := db.QueryRowContext(ctx, "PRAGMA journal_mode="+mode).Scan(&restored); err != nil {
+		return err
+	}
+	if !strings.EqualFold(restored, mode) {
+		return fmt.Errorf("couldn't restore journal_mode=%s; the database is still in %s mode", mode, restored)
+	}
+	return nil
+}
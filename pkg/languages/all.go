@@ -1,22 +1,39 @@
 package languages
 
-// an enumeration of all the allowed languages
-var Languages = map[string]int64{
-	"other":     -1,
-	"pgsql":     0,
-	"plpgsql":   1,
-	"psql":      2,
-	"plperl":    3,
-	"pltcl":     4,
-	"plpython2": 5,
-	"plpython3": 6,
+import "regexp"
+
+// the ids of the languages every corpus starts with, matching the rows that
+// schema.sql inserts and the splitter's Language enum. Any other language is
+// read from a corpus' languages table; see corpus.LookupLanguage.
+const (
+	Other     int64 = -1
+	Pgsql     int64 = 0
+	Plpgsql   int64 = 1
+	Psql      int64 = 2
+	Plperl    int64 = 3
+	Pltcl     int64 = 4
+	Plpython2 int64 = 5
+	Plpython3 int64 = 6
+)
+
+var procedural = []struct {
+	pattern *regexp.Regexp
+	id      int64
+}{
+	{regexp.MustCompile("(?i)^plpgsql$"), Plpgsql},
+	{regexp.MustCompile("(?i)^plperl$"), Plperl},
+	{regexp.MustCompile("(?i)^pltcl$"), Pltcl},
+	{regexp.MustCompile("(?i)^plpython2?u$"), Plpython2},
+	{regexp.MustCompile("(?i)^plpython3u$"), Plpython3},
 }
 
-func LookupId(language string) int64 {
-	if id, ok := Languages[language]; ok {
-		return id
-	} else {
-		// definitely other
-		return -1
+// IdentifyProcedural maps the name in e.g. `LANGUAGE plpython3u` to a language
+// id the same way the splitter does.
+func IdentifyProcedural(name string) int64 {
+	for _, language := range procedural {
+		if language.pattern.MatchString(name) {
+			return language.id
+		}
 	}
+	return Other
 }
@@ -4,13 +4,17 @@
 package container
 
 import (
+	"context"
 	"database/sql"
 	_ "database/sql"
 	"fmt"
 	"log"
+	"os/exec"
+	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"github.com/skalt/pg_sql_tests/pkg/corpus"
 )
 
 type Service struct {
@@ -40,22 +44,30 @@ func (service *Service) Dsn() string {
 	return *service.dsn
 }
 
-func (service *Service) isReady() bool {
+func (service *Service) isReady(ctx context.Context) bool {
 	db, err := sql.Open("postgres", service.Dsn())
 	if err != nil {
 		return false
 	}
-	_, err = db.Exec("SELECT 1;")
+	defer db.Close()
+	_, err = db.ExecContext(ctx, "SELECT 1;")
 	return err == nil
 }
 
-func (service *Service) Await() error {
+// Await polls the service until it accepts connections, gives up after ~15
+// seconds, or stops early if ctx is done.
+func (service *Service) Await(ctx context.Context) error {
 	// wait for the database server
 	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	for i := 0; i <= 15; i++ {
-		<-ticker.C // wait for a tick
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C: // wait for a tick
+		}
 
-		if service.isReady() {
+		if service.isReady(ctx) {
 			return nil
 		} else {
 			fmt.Printf(".")
@@ -64,6 +76,88 @@ func (service *Service) Await() error {
 	return fmt.Errorf("%s service startup timed out", service.Name())
 }
 
+// the postgres versions with a service defined in the top-level
+// docker-compose.yaml
+var Versions = []string{"10", "11", "12", "13", "14"}
+
+// the settings that change how the server lexes, parses, or runs statements
+var relevantSettings = []string{
+	"server_version_num",
+	"server_encoding",
+	"client_encoding",
+	"standard_conforming_strings",
+	"backslash_quote",
+	"escape_string_warning",
+	"DateStyle",
+	"IntervalStyle",
+	"TimeZone",
+	"lc_collate",
+	"lc_ctype",
+	"default_transaction_isolation",
+	"max_stack_depth",
+}
+
+// Describe fingerprints the running server: its `SELECT version()`, the
+// relevant settings as "guc.<name>", and the image digest if it can be found.
+func (service *Service) Describe(ctx context.Context) (corpus.OracleMetadata, error) {
+	db, err := sql.Open("postgres", service.Dsn())
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	metadata := corpus.OracleMetadata{}
+	var version string
+	if err := db.QueryRowContext(ctx, "SELECT version();").Scan(&version); err != nil {
+		return nil, err
+	}
+	metadata["server_version"] = version
+	rows, err := db.QueryContext(
+		ctx,
+		"SELECT name, setting FROM pg_settings WHERE name = ANY($1);",
+		pq.Array(relevantSettings),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, setting string
+		if err := rows.Scan(&name, &setting); err != nil {
+			return nil, err
+		}
+		metadata["guc."+name] = setting
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if digest, err := service.imageDigest(ctx); err != nil {
+		// e.g. in CI, where the services aren't managed by docker-compose
+		fmt.Printf("unable to find the image digest of %s: %v\n", service.Name(), err)
+	} else {
+		metadata["image_digest"] = digest
+	}
+	return metadata, nil
+}
+
+// find the digest of the image the service's container is running
+func (service *Service) imageDigest(ctx context.Context) (string, error) {
+	imageId, err := exec.CommandContext(ctx, "docker-compose", "images", "-q", service.Name()).Output()
+	if err != nil {
+		return "", err
+	}
+	id := strings.TrimSpace(string(imageId))
+	if id == "" {
+		return "", fmt.Errorf("no image found")
+	}
+	digest, err := exec.CommandContext(
+		ctx, "docker", "image", "inspect", "--format", "{{index .RepoDigests 0}}", id,
+	).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(digest)), nil
+}
+
 func DeriveServiceName(version string) (string, error) {
 	switch version {
 	case "10":
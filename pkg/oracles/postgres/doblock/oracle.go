@@ -33,44 +33,74 @@ import (
 
 	"github.com/skalt/pg_sql_tests/pkg/corpus"
 	"github.com/skalt/pg_sql_tests/pkg/languages"
+	"github.com/skalt/pg_sql_tests/pkg/oracles"
 	"github.com/skalt/pg_sql_tests/pkg/oracles/postgres/container"
 	raw "github.com/skalt/pg_sql_tests/pkg/oracles/postgres/driver"
+	"github.com/skalt/pg_sql_tests/pkg/oracles/registry"
 )
 
-func testify(conn *sql.Tx, statement *corpus.Statement, languageId int64) corpus.Prediction {
+var capabilities = oracles.Capabilities{
+	Languages: []string{"pgsql", "plpgsql"},
+	Versions:  container.Versions,
+}
+
+func init() {
+	registry.Register(&registry.Factory{
+		Name:         "do-block",
+		Capabilities: capabilities,
+		Timeout:      time.Second,
+		New: func(ctx context.Context, language string, version string) (oracles.Oracle, error) {
+			oracle, err := Init(ctx, language, version)
+			if err != nil {
+				return nil, err
+			}
+			return oracle, nil
+		},
+	})
+}
+
+// wraps each statement: %[1]s is the dollar-quote delimiter, %[2]s the statement
+const wrapperTemplate = "DO $%[1]s$BEGIN RETURN; %[2]s END;$%[1]s$;"
+
+const sessionOptions = "SET check_function_bodies = ON;"
+
+func testify(ctx context.Context, conn *sql.Tx, statement *corpus.Statement, languageId int64) corpus.Prediction {
 	delim := "SYNTAX_CHECK" // TODO: check string not present in _
 	extendedStatement := corpus.Statement{
 		Id:   statement.Id,
-		Text: fmt.Sprintf("DO $%s$BEGIN RETURN; %s END;$%s$;", delim, statement.Text, delim),
+		Text: fmt.Sprintf(wrapperTemplate, delim, statement.Text),
 	}
-	return raw.Predict(conn, &extendedStatement, languageId)
+	return raw.Predict(ctx, conn, &extendedStatement, languageId)
 }
 
 type Oracle struct {
-	version string
-	service *container.Service
-	db      *sql.DB
+	version  string
+	server   *raw.Server
+	metadata corpus.OracleMetadata
+	id       int64
 }
 
-func Init(language string, version string) (*Oracle, error) {
-	switch language {
-	case "pgsql":
-	case "plpgsql":
-		break
-	default:
-		return nil, fmt.Errorf("unsupported language %s", language)
+func Init(ctx context.Context, language string, version string) (*Oracle, error) {
+	if err := capabilities.Check(language, version); err != nil {
+		return nil, err
 	}
 	service := container.InitService(version)
-	if err := service.Await(); err != nil {
-		log.Panic(err)
+	server, err := raw.Connect(ctx, service)
+	if err != nil {
+		return nil, err
 	}
-	// service.Start() guarantees that service.Dsn() will connect on the first
+	// raw.Connect() guarantees that service.Dsn() will connect on the first
 	// try
-	conn, err := sql.Open("postgres", service.Dsn())
+	metadata, err := service.Describe(ctx)
 	if err != nil {
-		log.Panic(err)
+		server.Close()
+		return nil, err
 	}
-	oracle := Oracle{version, service, conn}
+	metadata["version"] = version
+	metadata["session_options"] = sessionOptions
+	metadata["wrapper_template"] = wrapperTemplate
+	oracle := Oracle{version: version, server: server, metadata: metadata}
+	oracle.id = corpus.DeriveOracleId(oracle.GetName(), metadata)
 	return &oracle, nil
 }
 
@@ -79,39 +109,36 @@ func (d *Oracle) GetName() string {
 }
 
 func (oracle *Oracle) GetId() int64 {
-	return corpus.DeriveOracleId(oracle.GetName())
+	return oracle.id
+}
+
+func (oracle *Oracle) GetMetadata() corpus.OracleMetadata {
+	return oracle.metadata
+}
+
+func (*Oracle) GetCapabilities() oracles.Capabilities {
+	return capabilities
 }
 
-func (oracle *Oracle) Predict(statement *corpus.Statement, languageId int64) (*corpus.Prediction, error) {
+func (oracle *Oracle) Predict(ctx context.Context, statement *corpus.Statement, languageId int64) (*corpus.Prediction, error) {
 	switch languageId {
-	case languages.Languages["pgsql"]:
-	case languages.Languages["plpgsql"]:
+	case languages.Pgsql:
+	case languages.Plpgsql:
 		break
 	default:
 		return nil, fmt.Errorf("unsupported language %d", languageId)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
-	if _, err := oracle.db.Exec("SET check_function_bodies = ON;"); err != nil {
-		// the database is closed?
-		return nil, err
-	}
-	txn, err := oracle.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	testimony, err := oracle.server.Predict(ctx, sessionOptions, statement, languageId, testify)
 	if err != nil {
 		return nil, err
 	}
-
-	testimony := testify(txn, statement, languageId)
-	if err := txn.Rollback(); err != nil {
-		return nil, err
-	}
 	testimony.OracleId = oracle.GetId()
-	return &testimony, nil
+	return testimony, nil
 }
 
 func (d *Oracle) Close() {
 	fmt.Println("closing do-block oracle")
-	if err := d.db.Close(); err != nil {
+	if err := d.server.Close(); err != nil {
 		log.Panic(err)
 	}
 }
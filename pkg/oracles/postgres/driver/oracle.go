@@ -3,17 +3,43 @@ package driver
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"time"
 
 	"github.com/lib/pq"
 	"github.com/skalt/pg_sql_tests/pkg/corpus"
 	"github.com/skalt/pg_sql_tests/pkg/languages"
+	"github.com/skalt/pg_sql_tests/pkg/oracles"
 	"github.com/skalt/pg_sql_tests/pkg/oracles/postgres/container"
+	"github.com/skalt/pg_sql_tests/pkg/oracles/registry"
 )
 
+var capabilities = oracles.Capabilities{
+	Languages: []string{"pgsql", "plpgsql"},
+	Versions:  container.Versions,
+}
+
+func init() {
+	registry.Register(&registry.Factory{
+		Name:         "raw",
+		Capabilities: capabilities,
+		Timeout:      time.Second,
+		New: func(ctx context.Context, language string, version string) (oracles.Oracle, error) {
+			oracle, err := Init(ctx, language, version)
+			if err != nil {
+				return nil, err
+			}
+			return oracle, nil
+		},
+	})
+}
+
 func SyntaxIsOk(err *pq.Error) (validSyntax bool, testimony string) {
 	data, e := json.Marshal(err)
 	if e != nil {
@@ -38,74 +64,219 @@ func SyntaxIsOk(err *pq.Error) (validSyntax bool, testimony string) {
 	return validSyntax, testimony
 }
 
-func Predict(txn *sql.Tx, statement *corpus.Statement, languageId int64) corpus.Prediction {
+// whether the SQLSTATE might be raised before or after the statement is
+// parsed, and so says nothing about whether the statement's syntax is valid
+func isAmbiguous(code pq.ErrorCode) bool {
+	switch code {
+	case "03000", // sql_statement_not_yet_complete
+		"3D000", // invalid_catalog_name
+		"3F000", // invalid_schema_name
+		"26000", // invalid_sql_statement_name
+		// "2201E", // invalid_argument_for_logarithm",
+		// "22014", // invalid_argument_for_ntile_function",
+		// "22016", // invalid_argument_for_nth_value_function",
+		// "2201F", // invalid_argument_for_power_function",
+		// "2201G", // invalid_argument_for_width_bucket_function",
+		"22019", // invalid_escape_character
+		"2200D", // invalid_escape_octet
+		"22025", // invalid_escape_sequence
+		// "22P02", // invalid_text_representation
+		// "2200M", // invalid_xml_document
+		// "2200N", // invalid_xml_content
+		// "2200S", // invalid_xml_comment
+		// "2200T", // invalid_xml_processing_instruction
+		"22P06", // nonstandard_use_of_escape_character
+		"22010", // invalid_indicator_parameter_value
+		"22023", // invalid_parameter_value
+		"2201B", // invalid_regular_expression
+		"22024": // unterminated_c_string
+		// "42846", // cannot_coerce
+		// "42803", // grouping_error
+		return true
+	default:
+		return false
+	}
+}
+
+// Classify grades an error the server raised while running a statement.
+func Classify(err *pq.Error) corpus.Outcome {
+	switch {
+	case isAmbiguous(err.Code):
+		return corpus.OutcomeAmbiguous
+	case err.Code == "57014": // query_canceled, e.g. by statement_timeout
+		return corpus.OutcomeTimeout
+	case err.Code.Class() == "08", // connection_exception
+		err.Code == "57P01", // admin_shutdown
+		err.Code == "57P02", // crash_shutdown
+		err.Code == "57P03": // cannot_connect_now
+		return corpus.OutcomeServerCrash
+	case err.Code.Class() == "42": // syntax_error_or_access_rule_violation
+		if valid, _ := SyntaxIsOk(err); !valid {
+			return corpus.OutcomeSyntaxError
+		}
+		return corpus.OutcomeSemanticError
+	default:
+		return corpus.OutcomeRuntimeError
+	}
+}
+
+// whether a non-postgres error means the connection to the server went away,
+// most likely because the statement crashed the server
+func isConnectionLost(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &netErr)
+}
+
+// Grade records an error from running a statement, or from getting a
+// connection ready to run it, as the statement's outcome.
+func Grade(ctx context.Context, err error, testimony *corpus.Prediction) {
+	if ctx.Err() != nil {
+		// the deadline passed (or the run was cancelled) and the server was
+		// told to cancel the statement, so there's no verdict to be had
+		testimony.Error = fmt.Sprintf("%s: %s", ctx.Err(), err)
+		testimony.Outcome = corpus.OutcomeTimeout
+		return
+	}
+	if e, ok := err.(*pq.Error); ok {
+		data, err := json.Marshal(e)
+		if err != nil {
+			testimony.Error = fmt.Sprintf("%s", e)
+			testimony.Outcome = corpus.OutcomeOracleFailure
+			return
+		}
+		testimony.Error = string(data)
+		testimony.Outcome = Classify(e)
+		return
+	}
+	testimony.Error = fmt.Sprintf("%s", err)
+	if isConnectionLost(err) {
+		testimony.Outcome = corpus.OutcomeServerCrash
+	} else {
+		testimony.Outcome = corpus.OutcomeOracleFailure
+	}
+}
+
+func Predict(ctx context.Context, txn *sql.Tx, statement *corpus.Statement, languageId int64) corpus.Prediction {
 	testimony := corpus.Prediction{
 		StatementId: statement.Id,
 		LanguageId:  languageId,
 	}
-	if _, err := txn.Exec(statement.Text); err != nil {
-		if e, ok := err.(*pq.Error); ok {
-			switch e.Code {
-			case "03000": // sql_statement_not_yet_complete
-			case "3D000": // invalid_catalog_name
-			case "3F000": // invalid_schema_name
-			case "26000": // invalid_sql_statement_name
-			// case "2201E": // invalid_argument_for_logarithm",
-			// case "22014": // invalid_argument_for_ntile_function",
-			// case "22016": // invalid_argument_for_nth_value_function",
-			// case "2201F": // invalid_argument_for_power_function",
-			// case "2201G": // invalid_argument_for_width_bucket_function",
-			case "22019": // invalid_escape_character
-			case "2200D": // invalid_escape_octet
-			case "22025": // invalid_escape_sequence
-			// case "22P02": // invalid_text_representation
-			// case "2200M": // invalid_xml_document
-			// case "2200N": // invalid_xml_content
-			// case "2200S": // invalid_xml_comment
-			// case "2200T": // invalid_xml_processing_instruction
-			case "22P06": // nonstandard_use_of_escape_character
-			case "22010": // invalid_indicator_parameter_value
-			case "22023": // invalid_parameter_value
-			case "2201B": // invalid_regular_expression
-			case "22024": // unterminated_c_string
-				// case "42846": // cannot_coerce
-				// case "42803": // grouping_error
-				fmt.Printf("%s: %s\n----------\n%+v\n", e.Code, statement.Text, e)
-				testimony.Valid = nil
-				data, err := json.Marshal(e)
-				if err != nil {
-					panic(err)
-				}
-				testimony.Error = string(data)
-				return testimony
-			default:
-				valid, etc := SyntaxIsOk(e)
-				testimony.Valid = &valid
-				testimony.Error = etc
-				return testimony
-			}
-		} else {
-			testimony.Error = fmt.Sprintf("%s", err)
-			testimony.Valid = nil
-		}
-	} else {
+	_, err := txn.ExecContext(ctx, statement.Text)
+	if err == nil {
 		// TODO: use the result here?
-		valid := true
-		testimony.Valid = &valid
+		testimony.Outcome = corpus.OutcomeValid
+		return testimony
 	}
+	Grade(ctx, err, &testimony)
 	return testimony
 }
 
-type Oracle struct {
-	id      *int64
+// a Server runs statements against a postgres service, one rolled-back
+// transaction per statement.
+type Server struct {
+	// the context the oracle was made with, which lasts the whole run rather
+	// than one statement
+	run     context.Context
 	service *container.Service
 	db      *sql.DB
-	version string
+}
+
+// Connect waits for the service to be ready and opens a connection pool to it.
+func Connect(ctx context.Context, service *container.Service) (*Server, error) {
+	if err := service.Await(ctx); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("postgres", service.Dsn())
+	if err != nil {
+		return nil, err
+	}
+	return &Server{run: ctx, service: service, db: db}, nil
+}
+
+// begin a transaction with the given session options applied.
+func (s *Server) begin(ctx context.Context, options string) (*sql.Tx, error) {
+	txn, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return nil, err
+	}
+	// set inside the transaction so that the setting applies to the same
+	// pooled connection that runs the statement
+	if _, err := txn.ExecContext(ctx, options); err != nil {
+		_ = txn.Rollback()
+		return nil, err
+	}
+	return txn, nil
+}
+
+// Predict runs predict on the statement in a transaction with the session
+// options applied, then rolls the transaction back.
+//
+// If the server doesn't respond, most likely because the previous statement
+// crashed it, Predict waits for the service to come back and tries once more.
+// That wait is bounded by the run rather than by ctx, whose deadline would
+// pass long before a restarted server is ready, and the statement's deadline
+// is pushed back by however long the wait took. Failing to get a connection
+// ready is graded like failing to run the statement; Predict only returns an
+// error if the service doesn't come back or the run is cancelled while waiting.
+func (s *Server) Predict(
+	ctx context.Context,
+	options string,
+	statement *corpus.Statement,
+	languageId int64,
+	predict func(context.Context, *sql.Tx, *corpus.Statement, int64) corpus.Prediction,
+) (*corpus.Prediction, error) {
+	txn, err := s.begin(ctx, options)
+	if err != nil && ctx.Err() == nil {
+		// the database probably crashed; see `docker-compose logs --tail=100 pg-${version:-14}`
+		// wait for the service to return to readiness:
+		start := time.Now()
+		if err := s.service.Await(s.run); err != nil {
+			return nil, err
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(s.run, deadline.Add(time.Since(start)))
+			defer cancel()
+		}
+		txn, err = s.begin(ctx, options)
+	}
+	if err != nil {
+		testimony := corpus.Prediction{StatementId: statement.Id, LanguageId: languageId}
+		Grade(ctx, err, &testimony)
+		return &testimony, nil
+	}
+	testimony := predict(ctx, txn, statement, languageId)
+	if err := txn.Rollback(); err != nil && testimony.Outcome == corpus.OutcomeValid {
+		// pass with uncertain marks in case of nested transactions, e.g. the
+		// statement was a COMMIT
+		testimony.Outcome = corpus.OutcomeAmbiguous
+	}
+	return &testimony, nil
+}
+
+func (s *Server) Close() error {
+	return s.db.Close()
+}
+
+// the session settings applied before running a statement of each language
+var sessionOptions = map[string]string{
+	"pgsql":   "SET check_function_bodies = off;", // avoid checking plpgsql syntax
+	"plpgsql": "SET check_function_bodies = on;",
+}
+
+type Oracle struct {
+	id       *int64
+	server   *Server
+	version  string
+	metadata corpus.OracleMetadata
 }
 
 func (oracle *Oracle) GetId() int64 {
 	if oracle.id == nil {
-		id := corpus.DeriveOracleId(oracle.GetName())
+		id := corpus.DeriveOracleId(oracle.GetName(), oracle.GetMetadata())
 		oracle.id = &id
 		return id
 	} else {
@@ -113,24 +284,26 @@ func (oracle *Oracle) GetId() int64 {
 	}
 }
 
-func Init(language string, version string) (*Oracle, error) {
-	switch language {
-	case "pgsql":
-	case "plpgsql":
-		break
-	default:
-		return nil, fmt.Errorf("unsupported language %s", language)
+func Init(ctx context.Context, language string, version string) (*Oracle, error) {
+	if err := capabilities.Check(language, version); err != nil {
+		return nil, err
 	}
 	service := container.InitService(version)
-	if err := service.Await(); err != nil {
-		log.Fatal(err)
+	server, err := Connect(ctx, service)
+	if err != nil {
+		return nil, err
 	}
-	// service.Await() guarantees that connecting to the service must now work
-	db, err := sql.Open("postgres", service.Dsn())
+	// Connect() guarantees that connecting to the service must now work
+	metadata, err := service.Describe(ctx)
 	if err != nil {
-		panic(err)
+		server.Close()
+		return nil, err
+	}
+	metadata["version"] = version
+	for language, options := range sessionOptions {
+		metadata["session_options."+language] = options
 	}
-	oracle := Oracle{service: service, db: db, version: version, id: nil}
+	oracle := Oracle{server: server, version: version, id: nil, metadata: metadata}
 	return &oracle, nil
 }
 
@@ -138,69 +311,36 @@ func (d *Oracle) GetName() string {
 	return fmt.Sprintf("postgres %s raw driver", d.version)
 }
 
-func (d *Oracle) Predict(statement *corpus.Statement, languageId int64) (*corpus.Prediction, error) {
+func (d *Oracle) GetMetadata() corpus.OracleMetadata {
+	return d.metadata
+}
+
+func (*Oracle) GetCapabilities() oracles.Capabilities {
+	return capabilities
+}
+
+func (d *Oracle) Predict(ctx context.Context, statement *corpus.Statement, languageId int64) (*corpus.Prediction, error) {
 	var options string
 
 	switch languageId {
-	case languages.Languages["pgsql"]:
-		options = "SET check_function_bodies = off;"
-		// avoid checking plpgsql syntax
-	case languages.Languages["plpgsql"]:
-		options = "SET check_function_bodies = on;"
+	case languages.Pgsql:
+		options = sessionOptions["pgsql"]
+	case languages.Plpgsql:
+		options = sessionOptions["plpgsql"]
 	default:
 		return nil, fmt.Errorf("unsupported languageId %d", languageId)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer func() { cancel() }()
-	var txn *sql.Tx
-	var err error
-	txn, err = d.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
-
-	if err != nil {
-		// the database crashed; see `docker-compose logs --tail=100 pg-${version:-14}`
-		// wait for the service to return to readiness:
-		cancel()
-		if err = d.service.Await(); err != nil {
-			panic(err)
-		}
-		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
-		defer cancel()
-		txn, err = d.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
-		if err != nil {
-			panic(err)
-		}
-	}
-	_, err = txn.Exec(options)
+	testimony, err := d.server.Predict(ctx, options, statement, languageId, Predict)
 	if err != nil {
-		// the database probably crashed again; see ``docker-compose logs --tail=100 pg-${version:-14}``
-		// wait for the service to return to readiness:
-		cancel()
-		if err = d.service.Await(); err != nil {
-			panic(err)
-		}
-		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
-		defer cancel()
-		txn, err = d.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
-		if err != nil {
-			panic(err)
-		}
-		_, err = txn.Exec(options)
-		if err != nil {
-			panic(err)
-		}
+		return nil, err
 	}
-
-	testimony := Predict(txn, statement, languageId)
 	testimony.OracleId = d.GetId()
-	if err := txn.Rollback(); err != nil {
-		testimony.Valid = nil // pass with uncertain marks in case of nested transactions
-	}
-	return &testimony, nil
+	return testimony, nil
 }
 
 func (oracle *Oracle) Close() {
 	fmt.Println("closing")
-	if err := oracle.db.Close(); err != nil {
+	if err := oracle.server.Close(); err != nil {
 		log.Panic(err)
 	}
 }
@@ -0,0 +1,198 @@
+package pgquery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	pg_query "github.com/pganalyze/pg_query_go/v2"
+	"github.com/skalt/pg_sql_tests/pkg/corpus"
+	"github.com/skalt/pg_sql_tests/pkg/languages"
+	"github.com/skalt/pg_sql_tests/pkg/oracles"
+	"github.com/skalt/pg_sql_tests/pkg/oracles/registry"
+)
+
+// The deparse oracle checks that pg_query can turn a statement's parse tree
+// back into sql that means the same thing: it parses the statement, deparses
+// the tree, re-parses the output, and compares the fingerprints and the parse
+// trees, minus their locations. Statements that don't survive the round trip
+// are graded corpus.OutcomeRoundTripMismatch.
+
+var deparseCapabilities = oracles.Capabilities{
+	Languages: []string{"pgsql"},
+	Versions:  capabilities.Versions,
+}
+
+func init() {
+	registry.Register(&registry.Factory{
+		Name:         "deparse",
+		Capabilities: deparseCapabilities,
+		New: func(_ context.Context, language string, _ string) (oracles.Oracle, error) {
+			if !deparseCapabilities.SupportsLanguage(language) {
+				return nil, fmt.Errorf("unsupported language %s", language)
+			}
+			return &DeparseOracle{}, nil
+		},
+	})
+}
+
+const deparseName = "libpg_query 13.X deparse"
+
+var deparseId int64 = corpus.DeriveOracleId(deparseName, metadata)
+
+type DeparseOracle struct{}
+
+func (*DeparseOracle) GetName() string {
+	return deparseName
+}
+
+func (*DeparseOracle) GetId() int64 {
+	return deparseId
+}
+
+func (*DeparseOracle) GetMetadata() corpus.OracleMetadata {
+	return metadata
+}
+
+func (*DeparseOracle) GetCapabilities() oracles.Capabilities {
+	return deparseCapabilities
+}
+
+// the fields of a parse tree that say where, not what
+var locationFields = map[string]bool{"location": true, "stmt_location": true, "stmt_len": true}
+
+// parseWithoutLocations parses the statement into its JSON parse tree, minus
+// the locations.
+func parseWithoutLocations(text string) (interface{}, error) {
+	tree, err := pg_query.ParseToJSON(text)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	if err := json.Unmarshal([]byte(tree), &result); err != nil {
+		return nil, err
+	}
+	return stripLocations(result), nil
+}
+
+func stripLocations(node interface{}) interface{} {
+	switch node := node.(type) {
+	case map[string]interface{}:
+		for key, value := range node {
+			if locationFields[key] {
+				delete(node, key)
+			} else {
+				node[key] = stripLocations(value)
+			}
+		}
+	case []interface{}:
+		for i, value := range node {
+			node[i] = stripLocations(value)
+		}
+	}
+	return node
+}
+
+// firstDifference finds the path to the first place the trees differ.
+func firstDifference(a interface{}, b interface{}, path string) string {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok {
+			return path
+		}
+		keys := []string{}
+		for key := range a {
+			keys = append(keys, key)
+		}
+		for key := range b {
+			if _, ok := a[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if !reflect.DeepEqual(a[key], b[key]) {
+				return firstDifference(a[key], b[key], path+"."+key)
+			}
+		}
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return path
+		}
+		for i := range a {
+			if !reflect.DeepEqual(a[i], b[i]) {
+				return firstDifference(a[i], b[i], fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	}
+	return path
+}
+
+type roundTrip struct {
+	Deparsed string `json:"deparsed,omitempty"`
+}
+
+func predictRoundTrip(statement *corpus.Statement) *corpus.Prediction {
+	testimony := corpus.Prediction{
+		OracleId:    deparseId,
+		LanguageId:  languages.Pgsql,
+		StatementId: statement.Id,
+	}
+	mismatch := func(format string, args ...interface{}) *corpus.Prediction {
+		testimony.Outcome = corpus.OutcomeRoundTripMismatch
+		testimony.Error = fmt.Sprintf(format, args...)
+		return &testimony
+	}
+	tree, err := pg_query.Parse(statement.Text)
+	if err != nil {
+		testimony.Outcome = corpus.OutcomeSyntaxError
+		testimony.Error = err.Error()
+		return &testimony
+	}
+	deparsed, err := pg_query.Deparse(tree)
+	if err != nil {
+		return mismatch("deparsing: %v", err)
+	}
+	message, _ := json.Marshal(roundTrip{Deparsed: deparsed})
+	testimony.Message = string(message)
+	original, err := parseWithoutLocations(statement.Text)
+	if err != nil {
+		return mismatch("parsing the parse tree: %v", err)
+	}
+	reparsed, err := parseWithoutLocations(deparsed)
+	if err != nil {
+		return mismatch("re-parsing the deparsed statement: %v", err)
+	}
+	before, err := pg_query.FingerprintToUInt64(statement.Text)
+	if err != nil {
+		return mismatch("fingerprinting: %v", err)
+	}
+	after, err := pg_query.FingerprintToUInt64(deparsed)
+	if err != nil {
+		return mismatch("fingerprinting the deparsed statement: %v", err)
+	}
+	if before != after {
+		return mismatch("the fingerprint changed from %x to %x", before, after)
+	}
+	if !reflect.DeepEqual(original, reparsed) {
+		return mismatch("the parse tree changed at %s", firstDifference(original, reparsed, ""))
+	}
+	testimony.Outcome = corpus.OutcomeValid
+	return &testimony
+}
+
+// Predict runs in-process and quickly enough that it only checks whether the
+// context was already cancelled.
+func (*DeparseOracle) Predict(ctx context.Context, statement *corpus.Statement, languageId int64) (*corpus.Prediction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if languageId != languages.Pgsql {
+		return nil, fmt.Errorf("unsupported language ID %d", languageId)
+	}
+	return predictRoundTrip(statement), nil
+}
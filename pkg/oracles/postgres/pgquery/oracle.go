@@ -17,29 +17,70 @@
 package pgquery
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"runtime/debug"
 
 	pg_query "github.com/pganalyze/pg_query_go/v2"
 	"github.com/skalt/pg_sql_tests/pkg/corpus"
 	"github.com/skalt/pg_sql_tests/pkg/languages"
+	"github.com/skalt/pg_sql_tests/pkg/oracles"
+	"github.com/skalt/pg_sql_tests/pkg/oracles/registry"
 )
 
+var capabilities = oracles.Capabilities{
+	Languages: []string{"pgsql", "plpgsql"},
+	Versions:  []string{"13"}, // libpg_query 13-latest
+}
+
+func init() {
+	registry.Register(&registry.Factory{
+		Name:         "pg_query",
+		Capabilities: capabilities,
+		New: func(_ context.Context, language string, _ string) (oracles.Oracle, error) {
+			oracle, err := Init(language)
+			if err != nil {
+				return nil, err
+			}
+			return oracle, nil
+		},
+	})
+}
+
 const name = "libpg_query 13.X" // only retain postgres version, not libpg_query api version
-var id int64 = corpus.DeriveOracleId(name)
+var metadata = describe()
+var id int64 = corpus.DeriveOracleId(name, metadata)
+
+const pgQueryModule = "github.com/pganalyze/pg_query_go/v2"
+
+// describe which build of pg_query_go (and so libpg_query) is linked in
+func describe() corpus.OracleMetadata {
+	metadata := corpus.OracleMetadata{"version": "13"}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path != pgQueryModule {
+				continue
+			}
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+			metadata["pg_query_go_version"] = dep.Version
+			if dep.Sum != "" {
+				metadata["pg_query_go_sum"] = dep.Sum
+			}
+		}
+	}
+	return metadata
+}
 
 type Oracle struct{}
 
-func Init(language string) (oracle *Oracle, err error) {
-	switch language {
-
-	case "pgsql":
-	case "plpgsql":
-		oracle, err = &Oracle{}, nil
-	default:
-		oracle, err = nil, fmt.Errorf("unsupported language %s", language)
+func Init(language string) (*Oracle, error) {
+	if !capabilities.SupportsLanguage(language) {
+		return nil, fmt.Errorf("unsupported language %s", language)
 	}
-	return oracle, err
+	return &Oracle{}, nil
 }
 
 func (*Oracle) GetName() string {
@@ -50,6 +91,14 @@ func (*Oracle) GetId() int64 {
 	return id
 }
 
+func (*Oracle) GetMetadata() corpus.OracleMetadata {
+	return metadata
+}
+
+func (*Oracle) GetCapabilities() oracles.Capabilities {
+	return capabilities
+}
+
 type token struct {
 	Name  string
 	Start int32
@@ -62,7 +111,16 @@ type scanResult struct {
 }
 
 func (s *scanResult) String() string {
-	result, err := json.Marshal(s)
+	// pg_query's errors have no exported fields, so keep their text
+	var message *string
+	if s.Error != nil {
+		text := s.Error.Error()
+		message = &text
+	}
+	result, err := json.Marshal(struct {
+		Tokens []*token
+		Error  *string
+	}{s.Tokens, message})
 	if err != nil {
 		panic(err)
 	}
@@ -90,13 +148,12 @@ func getTokens(statement string) scanResult {
 func predictSql(statement *corpus.Statement) *corpus.Prediction {
 	testimony := corpus.Prediction{
 		OracleId:    id,
-		LanguageId:  languages.Languages["pgsql"],
+		LanguageId:  languages.Pgsql,
 		StatementId: statement.Id,
 	}
 	result := getTokens(statement.Text)
 	if result.Error != nil {
-		valid := false
-		testimony.Valid = &valid
+		testimony.Outcome = corpus.OutcomeSyntaxError
 		testimony.Message = result.String()
 		return &testimony
 	}
@@ -104,43 +161,44 @@ func predictSql(statement *corpus.Statement) *corpus.Prediction {
 
 	if err != nil {
 		result.Error = err
-		valid := false
-		testimony.Valid = &valid
+		testimony.Outcome = corpus.OutcomeSyntaxError
 		testimony.Message = result.String()
 		return &testimony
 	}
 	jsonResult := result.String()
 	testimony.Message = jsonResult[0:len(jsonResult)-1] + ", \"ast\": " + ast + "}"
-	valid := true
-	testimony.Valid = &valid
+	testimony.Outcome = corpus.OutcomeValid
 	return &testimony
 }
 
 func predictPlpgsql(statement *corpus.Statement) *corpus.Prediction {
 	testimony := corpus.Prediction{
 		OracleId:    id,
-		LanguageId:  languages.Languages["plpgsql"],
+		LanguageId:  languages.Plpgsql,
 		StatementId: statement.Id,
 	}
 	result, err := pg_query.ParsePlPgSqlToJSON(statement.Text)
 
 	if err != nil {
-		valid := false
-		testimony.Valid = &valid
+		testimony.Outcome = corpus.OutcomeSyntaxError
 		testimony.Error = fmt.Sprintf("%v", err)
 	} else {
-		valid := true
-		testimony.Valid = &valid
+		testimony.Outcome = corpus.OutcomeValid
 		testimony.Message = fmt.Sprintf("{ast:%s}", result)
 	}
 	return &testimony
 }
 
-func (*Oracle) Predict(statement *corpus.Statement, languageId int64) (*corpus.Prediction, error) {
+// Predict runs in-process and quickly enough that it only checks whether the
+// context was already cancelled.
+func (*Oracle) Predict(ctx context.Context, statement *corpus.Statement, languageId int64) (*corpus.Prediction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	switch languageId {
-	case languages.Languages["pgsql"]:
+	case languages.Pgsql:
 		return predictSql(statement), nil
-	case languages.Languages["plpgsql"]:
+	case languages.Plpgsql:
 		return predictPlpgsql(statement), nil
 	default:
 		return nil, fmt.Errorf("unsupported language ID %d", languageId)
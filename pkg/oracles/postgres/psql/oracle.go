@@ -1,30 +1,73 @@
 package psql
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"os/exec"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/skalt/pg_sql_tests/pkg/corpus"
 	"github.com/skalt/pg_sql_tests/pkg/languages"
+	"github.com/skalt/pg_sql_tests/pkg/oracles"
 	"github.com/skalt/pg_sql_tests/pkg/oracles/postgres/container"
+	"github.com/skalt/pg_sql_tests/pkg/oracles/registry"
 )
 
+var capabilities = oracles.Capabilities{
+	Languages: []string{"psql"},
+	Versions:  container.Versions,
+}
+
+func init() {
+	registry.Register(&registry.Factory{
+		Name:         "psql",
+		Capabilities: capabilities,
+		// no default deadline: starting psql with `docker-compose exec` alone
+		// can take about a second
+		New: func(ctx context.Context, language string, version string) (oracles.Oracle, error) {
+			oracle, err := Init(ctx, language, version)
+			if err != nil {
+				return nil, err
+			}
+			return oracle, nil
+		},
+	})
+}
+
+const psqlFlags = "--set=ON_ERROR_STOP=on"
+
 type Oracle struct {
-	version string
-	service *container.Service
+	version  string
+	service  *container.Service
+	metadata corpus.OracleMetadata
+	id       int64
 }
 
-func Init(language string, version string) (*Oracle, error) {
-	if language != "psql" {
-		return nil, fmt.Errorf("invalid language %s; only `psql` allowed", language)
+func Init(ctx context.Context, language string, version string) (*Oracle, error) {
+	if err := capabilities.Check(language, version); err != nil {
+		return nil, err
 	}
 	service := container.InitService(version)
-	if err := service.Await(); err != nil {
-		log.Panic(err)
+	if err := service.Await(ctx); err != nil {
+		return nil, err
+	}
+	metadata, err := service.Describe(ctx)
+	if err != nil {
+		return nil, err
 	}
-	oracle := Oracle{version, service}
+	metadata["version"] = version
+	metadata["psql_flags"] = psqlFlags
+	psqlVersion, err := exec.CommandContext(
+		ctx, "docker-compose", "exec", "-T", service.Name(), "psql", "--version",
+	).Output()
+	if err != nil {
+		return nil, err
+	}
+	metadata["psql_version"] = strings.TrimSpace(string(psqlVersion))
+	oracle := Oracle{version: version, service: service, metadata: metadata}
+	oracle.id = corpus.DeriveOracleId(oracle.GetName(), metadata)
 	return &oracle, nil
 }
 
@@ -50,6 +93,15 @@ func hasUnrecognizedValue(stderr string) bool {
 	return startsWith(stderr, unrecognizedValue)
 }
 
+// psql prefixes messages about scripts read from stdin with their location,
+// e.g. `psql:<stdin>:1: ERROR:  syntax error at or near "foo"`
+var locationPrefix = regexp.MustCompile(`^psql:[^:]*:\d+: `)
+
+func firstMessage(stderr string) string {
+	line := strings.SplitN(stderr, "\n", 2)[0]
+	return locationPrefix.ReplaceAllString(line, "")
+}
+
 func hasSqlishSyntaxError(stderr string) bool {
 	if !startsWith(stderr, "ERROR:") {
 		return false
@@ -59,33 +111,63 @@ func hasSqlishSyntaxError(stderr string) bool {
 }
 
 func (psql *Oracle) GetId() int64 {
-	return corpus.DeriveOracleId(psql.GetName())
+	return psql.id
+}
+
+func (psql *Oracle) GetMetadata() corpus.OracleMetadata {
+	return psql.metadata
+}
+
+func (*Oracle) GetCapabilities() oracles.Capabilities {
+	return capabilities
 }
 
 // there are more ways to parse psql's stderr, e.g. "ERROR:  invalid input syntax"
 // but that is better done by consenting adults as queries on the resulting corpus database
 // ERROR:  syntax error
 
-func (psql *Oracle) Predict(statement *corpus.Statement, languageId int64) (*corpus.Prediction, error) {
+// the arguments to `docker-compose exec` that run psql inside the service
+func (psql *Oracle) execArgs(ctx context.Context) []string {
+	args := []string{"exec", "-T"}
+	// -T: don't allocate a pseudo-TTY
+	if deadline, ok := ctx.Deadline(); ok {
+		// killing `docker-compose exec` doesn't stop the server from running the
+		// statement, so also ask the server to give up at the deadline
+		ms := time.Until(deadline).Milliseconds()
+		if ms < 1 {
+			ms = 1
+		}
+		args = append(args, "-e", fmt.Sprintf("PGOPTIONS=-c statement_timeout=%d", ms))
+	}
+	return append(args, psql.service.Name(), "psql", psqlFlags)
+}
+
+func (psql *Oracle) Predict(ctx context.Context, statement *corpus.Statement, languageId int64) (*corpus.Prediction, error) {
 	prediction := corpus.Prediction{
 		OracleId:    psql.GetId(),
 		StatementId: statement.Id,
-		LanguageId:  languages.Languages["psql"],
-		Valid:       nil,
+		LanguageId:  languages.Psql,
 	}
-	cmd := exec.Command("docker-compose", "exec", "-T", "psql", "--set=ON_ERROR_STOP=on")
-	// -T: don't allocate a pseudo-TTY            ^^^^
+	cmd := exec.CommandContext(ctx, "docker-compose", psql.execArgs(ctx)...)
+	// ^ killed once ctx is done
 	cmd.Stdin = strings.NewReader(statement.Text)
 	// ^ required for handling `COPY FROM STDIN`
 	// also see https://www.postgresql.org/docs/current/app-psql.html#R1-APP-PSQL-3
 	// for reasons why passing the statement as via the `--command` flag won't work
 
-	message, err := cmd.Output() // TODO: add timeout in case of long-running psql commands
+	message, err := cmd.Output()
+	prediction.Message = string(message)
+	if ctx.Err() != nil {
+		// timed out or cancelled: whatever psql said so far isn't a verdict
+		prediction.Error = fmt.Sprintf("%s: %v", ctx.Err(), err)
+		prediction.Outcome = corpus.OutcomeTimeout
+		return &prediction, nil
+	}
 	if err == nil {
 		// the command miraculously worked
 		prediction.Error = ""
 		// I'm not confident enough to mark not-erroring syntax as valid; no error
-		// is at least factual.
+		// is at least factual, so the outcome is ambiguous.
 		// For example,
 		// the following will pass the test:
 		// ```psql
@@ -95,21 +177,36 @@ func (psql *Oracle) Predict(statement *corpus.Statement, languageId int64) (*cor
 		// ```
 		// would pass with no error, but is completely invalid, while
 		// `select * from foo \g` would fail with a "relation does not exist"
-		prediction.Message = string(message)
-	} else {
+		prediction.Outcome = corpus.OutcomeAmbiguous
+		return &prediction, nil
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return nil, err // docker-compose couldn't be started
+	}
+	prediction.Error = string(exitErr.Stderr)
+	if prediction.Error == "" {
+		prediction.Error = err.Error()
+	}
+	switch exitErr.ExitCode() {
+	case 3:
 		// this is where the fun begins. Most of our psql commands will exit
 		// nonzero since (1) we set ON_ERROR_STOP=on and (2) most queries will
 		// use nonexistent database objects
-		stderr := err.Error()
-		prediction.Error = stderr
+		stderr := firstMessage(prediction.Error)
 		// definitelyInvalid meta-commands are reached before non-existent database objects
 		definitelyInvalid := isInvalidCommand(stderr) ||
 			hasUnrecognizedValue(stderr) ||
 			hasSqlishSyntaxError(stderr)
 		if definitelyInvalid {
-			valid := false
-			prediction.Valid = &valid
+			prediction.Outcome = corpus.OutcomeSyntaxError
+		} else {
+			prediction.Outcome = corpus.OutcomeAmbiguous
 		}
+	case 2: // psql's connection to the server went bad
+		prediction.Outcome = corpus.OutcomeServerCrash
+	default: // psql or docker-compose itself failed
+		prediction.Outcome = corpus.OutcomeOracleFailure
 	}
 	return &prediction, nil
 }
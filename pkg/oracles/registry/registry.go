@@ -0,0 +1,130 @@
+// Package registry tracks which oracles can be run. Each oracle package
+// registers a Factory from its init() function, much like database/sql drivers
+// do, so linking in an oracle is a matter of importing its package:
+//
+//	import _ "github.com/skalt/pg_sql_tests/pkg/oracles/postgres/driver"
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/skalt/pg_sql_tests/pkg/oracles"
+)
+
+// a Factory declares what an oracle can do and how to create one.
+type Factory struct {
+	// the name used to select the oracle on the command-line, e.g. "raw"
+	Name string
+	// the same capabilities as the oracles the factory creates report
+	oracles.Capabilities
+	// how long each prediction may take unless overridden on the command-line;
+	// 0 means no deadline
+	Timeout time.Duration
+	New     func(ctx context.Context, language string, version string) (oracles.Oracle, error)
+}
+
+var (
+	mu        sync.RWMutex
+	factories = map[string]*Factory{}
+)
+
+// Register makes an oracle available by name. It panics if the factory is
+// incomplete or if an oracle with the same name was already registered.
+func Register(factory *Factory) {
+	if factory == nil || factory.New == nil {
+		panic("registry: Register called with a nil factory")
+	}
+	if factory.Name == "" {
+		panic("registry: Register called with an unnamed factory")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, dup := factories[factory.Name]; dup {
+		panic(fmt.Sprintf("registry: Register called twice for oracle %s", factory.Name))
+	}
+	factories[factory.Name] = factory
+}
+
+func Lookup(name string) (factory *Factory, ok bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	factory, ok = factories[name]
+	return factory, ok
+}
+
+// All lists the registered factories in order of name.
+func All() []*Factory {
+	mu.RLock()
+	defer mu.RUnlock()
+	result := make([]*Factory, 0, len(factories))
+	for _, factory := range factories {
+		result = append(result, factory)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// Versions lists every version supported by at least one registered oracle.
+func Versions() []string {
+	seen := map[string]bool{}
+	versions := []string{}
+	for _, factory := range All() {
+		for _, version := range factory.Versions {
+			if !seen[version] {
+				seen[version] = true
+				versions = append(versions, version)
+			}
+		}
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// a Cell is one oracle run over one language at one version.
+type Cell struct {
+	Factory  *Factory
+	Version  string
+	Language string
+}
+
+func (cell Cell) String() string {
+	return fmt.Sprintf("oracle <%s> with language %s @ version %s", cell.Factory.Name, cell.Language, cell.Version)
+}
+
+// a Skip is a Cell that can't be run, and the reason why not.
+type Skip struct {
+	Cell
+	Reason error
+}
+
+// Plan expands every combination of the named oracles, versions, and languages
+// into the cells that can be run and the cells that must be skipped. Cells are
+// ordered by version, then oracle, then language. Plan fails if any of the
+// oracles aren't registered.
+func Plan(names []string, versions []string, languages []string) (runnable []Cell, skipped []Skip, err error) {
+	factories := make([]*Factory, len(names))
+	for i, name := range names {
+		factory, ok := Lookup(name)
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown oracle %s", name)
+		}
+		factories[i] = factory
+	}
+	for _, version := range versions {
+		for _, factory := range factories {
+			for _, language := range languages {
+				cell := Cell{Factory: factory, Version: version, Language: language}
+				if reason := factory.Check(language, version); reason != nil {
+					skipped = append(skipped, Skip{cell, reason})
+				} else {
+					runnable = append(runnable, cell)
+				}
+			}
+		}
+	}
+	return runnable, skipped, nil
+}
@@ -1,13 +1,63 @@
 package oracles
 
-import "github.com/skalt/pg_sql_tests/pkg/corpus"
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/skalt/pg_sql_tests/pkg/corpus"
+)
+
+// Capabilities declare which languages and versions an oracle can opine on.
+type Capabilities struct {
+	Languages []string
+	Versions  []string
+}
+
+func contains(haystack []string, needle string) bool {
+	for _, item := range haystack {
+		if item == needle {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Capabilities) SupportsLanguage(language string) bool {
+	return contains(c.Languages, language)
+}
+
+func (c Capabilities) SupportsVersion(version string) bool {
+	return contains(c.Versions, version)
+}
+
+// Check explains why an oracle can't handle the given language and version,
+// or returns nil if it can.
+func (c Capabilities) Check(language string, version string) error {
+	if !c.SupportsVersion(version) {
+		return fmt.Errorf(
+			"unsupported version %s; supported versions: %s",
+			version, strings.Join(c.Versions, ", "))
+	}
+	if !c.SupportsLanguage(language) {
+		return fmt.Errorf(
+			"unsupported language %s; supported languages: %s",
+			language, strings.Join(c.Languages, ", "))
+	}
+	return nil
+}
 
 // an oracle is something that takes some text and predicts whether
 // the statement is valid for a given sql-like dialect version.
 type Oracle interface {
 	GetName() string
-	// TODO: maybe Register(db *sql.DB) error
-	// derive its own id
+	// exactly which engine build and configuration the oracle consults
+	GetMetadata() corpus.OracleMetadata
+	// derive its own id from its name and metadata; see corpus.DeriveOracleId
 	GetId() int64
-	Predict(statement *corpus.Statement, languageId int64) (*corpus.Prediction, error)
+	// report which languages and versions the oracle can handle
+	GetCapabilities() Capabilities
+	// Predict should give up once ctx is done, including aborting any work it
+	// started outside of this process.
+	Predict(ctx context.Context, statement *corpus.Statement, languageId int64) (*corpus.Prediction, error)
 }
@@ -0,0 +1,231 @@
+package predict
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/cheggaaa/pb"
+	"github.com/skalt/pg_sql_tests/pkg/corpus"
+	"github.com/skalt/pg_sql_tests/pkg/oracles"
+)
+
+const (
+	batchSize = 1000
+	// psql predictions take long enough that a full batch could take minutes
+	flushInterval = 10 * time.Second
+)
+
+// bulkPredict runs the oracle over every statement in the language it hasn't
+// yet predicted. Statements are streamed from the corpus rather than loaded up
+// front, so memory use stays flat however large the corpus is. Each prediction
+// gets `timeout` to finish. Once ctx is done, no new statements are started,
+// in-flight predictions are discarded, and the predictions already made are
+// saved before returning ctx.Err(). If the oracle or the database fails, the
+// run stops the same way and returns that error instead.
+//
+// With dedup, the oracle only predicts one statement per fingerprint, and its
+// verdicts are copied to the rest once the run finishes.
+func bulkPredict(
+	ctx context.Context,
+	oracle oracles.Oracle,
+	language string,
+	db *sql.DB,
+	progress bool,
+	parallelism *uint,
+	timeout time.Duration,
+	dedup bool,
+) error {
+	lang, err := corpus.LookupLanguage(ctx, db, language)
+	if err != nil {
+		return err
+	}
+	languageId := lang.Id
+	oracleId := oracle.GetId()
+	fmt.Printf("running oracle `%s` (%x) for @language=%s\n", oracle.GetName(), oracleId, language)
+	if err := corpus.RegisterOracle(db, oracleId, oracle.GetName(), oracle.GetMetadata()); err != nil {
+		return err
+	}
+	filter := corpus.StatementFilter{LanguageId: &languageId, UnpredictedBy: &oracleId}
+	if dedup {
+		added, _, err := corpus.BackfillFingerprints(ctx, db)
+		if err != nil {
+			return err
+		}
+		if added > 0 {
+			fmt.Println("fingerprinted", added, "statements")
+		}
+		filter.Representatives = true
+	}
+	total, err := corpus.CountStatements(ctx, db, filter)
+	if err != nil {
+		return err
+	}
+	if total == 0 {
+		fmt.Println("no unpredicted statements found for language", language)
+		if dedup {
+			return propagate(ctx, db, oracleId, languageId)
+		}
+		return nil
+	}
+	nRoutines := runtime.NumCPU()*2 - 1
+	// ^ try not to gobble too much cpu+memory when docker containers running
+	if total < int64(nRoutines) {
+		nRoutines = int(total) - 1
+	}
+	if nRoutines <= 0 {
+		nRoutines = 2 // some sort of minimum concurrency
+	}
+	if parallelism != nil && *parallelism > 0 && int(*parallelism) < 3*runtime.NumCPU() {
+		nRoutines = int(*parallelism)
+	}
+	fmt.Println(nRoutines, "goroutines")
+
+	writer, err := corpus.NewPredictionWriter(ctx, db, corpus.WriterOptions{
+		BatchSize:     batchSize,
+		FlushInterval: flushInterval,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := writer.Close(context.Background()); err != nil {
+			fmt.Println("closing the prediction writer:", err)
+		}
+	}()
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var failure error
+	var once sync.Once
+	fail := func(err error) { // the first failure stops the run
+		once.Do(func() {
+			failure = err
+			cancel()
+		})
+	}
+
+	inputs := make(chan *corpus.Statement, nRoutines)
+	outputs := make(chan *corpus.Prediction, nRoutines)
+	var workers sync.WaitGroup
+	predict := func() {
+		defer workers.Done()
+		for statement := range inputs {
+			prediction, err := predictWithin(runCtx, timeout, oracle, statement, languageId)
+			if runCtx.Err() != nil {
+				continue // stopping; drain the remaining inputs
+			}
+			if err != nil {
+				fail(fmt.Errorf("predicting statement %x: %w", statement.Id, err))
+				continue
+			}
+			outputs <- prediction
+		}
+	}
+	for i := 0; i < nRoutines; i++ {
+		workers.Add(1)
+		go predict()
+	}
+	go func() {
+		workers.Wait()
+		close(outputs)
+	}()
+
+	var bar *pb.ProgressBar = nil
+	if progress { // HACK: dry this up
+		bar = pb.StartNew(int(total))
+		defer bar.Finish()
+	}
+	saved := make(chan struct{})
+	go func() {
+		defer close(saved)
+		if err := save(writer, outputs, bar); err != nil {
+			fail(err)
+		}
+	}()
+
+	statements := corpus.IterateStatements(runCtx, db, filter)
+	defer statements.Close()
+feed:
+	for statements.Next() {
+		select {
+		case inputs <- statements.Statement():
+		case <-runCtx.Done():
+			break feed
+		}
+	}
+	close(inputs)
+	if err := statements.Err(); err != nil && runCtx.Err() == nil {
+		fail(err)
+	}
+	<-saved
+	if failure != nil {
+		return failure
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if dedup {
+		return propagate(ctx, db, oracleId, languageId)
+	}
+	return nil
+}
+
+// predictWithin gives the oracle timeout to predict the statement; a timeout
+// of 0 leaves only ctx's deadline.
+func predictWithin(
+	ctx context.Context,
+	timeout time.Duration,
+	oracle oracles.Oracle,
+	statement *corpus.Statement,
+	languageId int64,
+) (*corpus.Prediction, error) {
+	if timeout <= 0 {
+		return oracle.Predict(ctx, statement, languageId)
+	}
+	statementCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return oracle.Predict(statementCtx, statement, languageId)
+}
+
+// closeOracle stops whatever the oracle started, e.g. a container, if it has
+// anything to stop.
+func closeOracle(oracle oracles.Oracle) {
+	if closer, ok := oracle.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+func propagate(ctx context.Context, db *sql.DB, oracleId int64, languageId int64) error {
+	inferred, err := corpus.PropagatePredictions(ctx, db, oracleId, languageId)
+	if err != nil {
+		return err
+	}
+	if inferred > 0 {
+		fmt.Println("inferred", inferred, "predictions from statements with the same fingerprint")
+	}
+	return nil
+}
+
+// save writes predictions until outputs is closed. After an error it keeps
+// draining outputs so that the workers don't block.
+func save(writer *corpus.PredictionWriter, outputs <-chan *corpus.Prediction, bar *pb.ProgressBar) error {
+	defer func() {
+		for range outputs {
+		}
+	}()
+	// keep saving after an interrupt; only the in-flight predictions get dropped
+	ctx := context.Background()
+	for prediction := range outputs {
+		if err := writer.Write(ctx, prediction); err != nil {
+			return err
+		}
+		if bar != nil {
+			bar.Increment()
+		}
+	}
+	return writer.Flush(ctx)
+}
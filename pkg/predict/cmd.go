@@ -0,0 +1,300 @@
+// Package predict implements the `predict` command, which runs registered
+// oracles over an existing corpus database. It lives outside of
+// scripts/predict so that programs in other modules can link in their own
+// oracles:
+//
+//	import (
+//		"github.com/skalt/pg_sql_tests/pkg/predict"
+//		_ "github.com/skalt/pg_sql_tests/pkg/oracles/postgres/driver"
+//		_ "example.com/my/private/oracle"
+//	)
+//
+//	func main() { predict.Execute() }
+package predict
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mattn/go-isatty"
+	"github.com/skalt/pg_sql_tests/pkg/corpus"
+	"github.com/skalt/pg_sql_tests/pkg/oracles/registry"
+	"github.com/spf13/cobra"
+)
+
+var Command = &cobra.Command{
+	Use:   "predict",
+	Short: "Have a series of oracles opine on whether statements are valid",
+	Run: func(cmd *cobra.Command, args []string) {
+		config := initConfig(cmd)
+		// validate that the oracles can support the given versions+languages
+		// **before** trying to run any of them
+		cells, skipped, err := registry.Plan(config.oracles, config.versions, config.languages)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, skip := range skipped {
+			fmt.Printf("skipping %s: %v\n", skip.Cell, skip.Reason)
+		}
+		if len(cells) == 0 {
+			fmt.Println("none of the requested oracles support the requested versions and languages")
+			os.Exit(1)
+		}
+		for _, cell := range cells {
+			err := runOracle(
+				cmd.Context(),
+				cell,
+				config.corpusPath,
+				config.dryRun,
+				config.progress,
+				config.parallelism,
+				config.timeout,
+				config.dedup,
+			)
+			if errors.Is(err, context.Canceled) {
+				fmt.Println("interrupted; stopping")
+				os.Exit(130)
+			} else if err != nil {
+				log.Fatal(err)
+			}
+		}
+	},
+}
+
+func listOracles(tty bool) {
+	if tty {
+		// only print the header if the output isn't piped somewhere
+		fmt.Printf("%10s %-20s %s\n", "oracle", "versions", "languages")
+	}
+	for _, factory := range registry.All() {
+		fmt.Printf(
+			"%10s %-20s %s\n",
+			factory.Name,
+			strings.Join(factory.Versions, ", "),
+			strings.Join(factory.Languages, ", "),
+		)
+	}
+}
+
+var listOraclesCmd = &cobra.Command{
+	Use: "list-oracles",
+	Run: func(cmd *cobra.Command, args []string) {
+		listOracles(isatty.IsTerminal(os.Stdout.Fd()))
+	},
+}
+
+func runOracle(
+	ctx context.Context,
+	cell registry.Cell,
+	dsn string,
+	dryRun bool,
+	progress bool,
+	parallelism *uint,
+	timeout *time.Duration,
+	dedup bool,
+) error {
+	if dryRun {
+		fmt.Printf("would run %s\n", cell)
+		return nil
+	}
+	db, err := corpus.ConnectToExisting(dsn)
+	if err != nil {
+		return err
+	}
+	// the prediction writer switches the corpus to write-ahead logging, which
+	// can only be left once every connection to the corpus is closed
+	journalMode, err := corpus.JournalMode(ctx, db)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	defer func() {
+		db.Close()
+		if err := corpus.RestoreJournalMode(context.Background(), dsn, journalMode); err != nil {
+			fmt.Println("restoring the journal mode:", err)
+		}
+	}()
+	oracle, err := cell.Factory.New(ctx, cell.Language, cell.Version)
+	if err != nil {
+		return err
+	}
+	defer closeOracle(oracle)
+	deadline := cell.Factory.Timeout
+	if timeout != nil {
+		deadline = *timeout
+	}
+	return bulkPredict(ctx, oracle, cell.Language, db, progress, parallelism, deadline, dedup)
+}
+
+type configuration struct {
+	corpusPath  string
+	oracles     []string
+	languages   []string
+	versions    []string
+	dryRun      bool
+	progress    bool
+	parallelism *uint
+	timeout     *time.Duration // nil means each oracle's default
+	dedup       bool
+}
+
+func init() {
+	cmd := Command
+	cmd.SilenceErrors = true // Execute prints them, except for interruptions
+	cmd.Flags().String("corpus", "./corpus.db", "path to the sqlite corpus database")
+	cmd.Flags().StringSlice("oracles", []string{"pg_query"}, "list which oracles to run")
+	cmd.Flags().StringSlice("language", []string{"pgsql"}, "which languages to try")
+	cmd.Flags().StringSlice("versions", []string{"14"}, "which postgres versions to try")
+	cmd.Flags().Bool("dry-run", false, "print the configuration rather than running the oracles")
+	cmd.PersistentFlags().Bool("progress", isatty.IsTerminal(os.Stdout.Fd()), "render a progress bar")
+	cmd.PersistentFlags().Bool("no-progress", false, "don't render a progress bar even when stdout is a tty")
+	cmd.Flags().Uint("parallelism", 0, "set the number of goroutines")
+	cmd.Flags().Duration(
+		"timeout", 0,
+		"how long each oracle may spend on each statement; 0 disables the deadline.\n"+
+			"Defaults to each oracle's own deadline: 1s for raw and do-block, none for the others",
+	)
+	cmd.Flags().Bool(
+		"dedup", false,
+		"only predict one statement per fingerprint and copy its verdict to the rest, marked as inferred.\n"+
+			"Statements that differ only in constants may still differ in outcome, e.g. runtime errors",
+	)
+	cmd.AddCommand(listOraclesCmd)
+}
+
+func initConfig(cmd *cobra.Command) *configuration {
+	fail := false
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		fmt.Printf("--dry-run: %s\n", err)
+		fail = true
+	}
+
+	corpus, err := cmd.Flags().GetString("corpus")
+	if err != nil {
+		fmt.Printf("--corpus: %s\n", err)
+	} else {
+		if _, err = os.Stat(corpus); err != nil {
+			fmt.Printf("--corpus: %s\n", err)
+			// primitive does-file-exist
+			fail = true
+		}
+	}
+
+	oracles, err := cmd.Flags().GetStringSlice("oracles")
+	if err != nil {
+		fmt.Printf("--oracle: %s\n", err)
+		fail = true
+	} else {
+		for _, oracle := range oracles {
+			if _, ok := registry.Lookup(oracle); !ok {
+				fmt.Printf("--oracle: unknown oracle %s\n", oracle)
+				fail = true
+			}
+		}
+	}
+
+	versions, err := cmd.Flags().GetStringSlice("versions")
+	if err != nil {
+		fail = true
+		fmt.Printf("--version: %s\n", err)
+	} else {
+		knownVersions := registry.Versions()
+		for _, version := range versions {
+			recognized := false
+			for _, v := range knownVersions {
+				if version == v {
+					recognized = true
+					break
+				}
+			}
+			if !recognized {
+				fail = true
+				fmt.Printf("--version: unknown postgres version %s\n", version)
+			}
+		}
+	}
+
+	// the oracles' capabilities and the corpus' languages table vet these
+	requestedLanguages, err := cmd.Flags().GetStringSlice("language")
+	if err != nil {
+		fail = true
+		fmt.Printf("--language: %s", err)
+	}
+	progress, err := cmd.Flags().GetBool("progress")
+	if err != nil {
+		fail = true
+		fmt.Printf("--progress: %v", err)
+	}
+	noProgress, err := cmd.Flags().GetBool("no-progress")
+	if err != nil {
+		fail = true
+		fmt.Printf("--no-progress: %v", err)
+	}
+	progress = progress && !noProgress
+
+	var nGoRoutines uint
+	var parallelism *uint = nil
+	nGoRoutines, err = cmd.Flags().GetUint("parallelism")
+	if err != nil {
+		fail = true
+		fmt.Printf("--parallelism: %v", err)
+	} else if nGoRoutines > 0 {
+		parallelism = &nGoRoutines
+	}
+	var timeout *time.Duration = nil
+	if cmd.Flags().Changed("timeout") {
+		deadline, err := cmd.Flags().GetDuration("timeout")
+		if err != nil {
+			fail = true
+			fmt.Printf("--timeout: %v", err)
+		}
+		timeout = &deadline
+	}
+	dedup, err := cmd.Flags().GetBool("dedup")
+	if err != nil {
+		fail = true
+		fmt.Printf("--dedup: %v", err)
+	}
+	if fail {
+		os.Exit(1)
+	}
+	config := configuration{
+		dryRun:      dryRun,
+		corpusPath:  corpus,
+		versions:    versions,
+		oracles:     oracles,
+		languages:   requestedLanguages,
+		progress:    progress,
+		parallelism: parallelism,
+		timeout:     timeout,
+		dedup:       dedup,
+	}
+	return &config
+}
+
+// Execute runs the predict command until it finishes or the process is
+// interrupted. The first SIGINT/SIGTERM stops the run cleanly, exiting with
+// status 130 once the subcommand has cleaned up; a second one kills the process.
+func Execute() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	err := Command.ExecuteContext(ctx)
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("interrupted; stopping")
+		os.Exit(130)
+	} else if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
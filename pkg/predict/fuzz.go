@@ -0,0 +1,328 @@
+package predict
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	pg_query "github.com/pganalyze/pg_query_go/v2"
+	"github.com/skalt/pg_sql_tests/pkg/corpus"
+	"github.com/skalt/pg_sql_tests/pkg/oracles"
+	"github.com/skalt/pg_sql_tests/pkg/oracles/registry"
+	"github.com/spf13/cobra"
+)
+
+var fuzzCmd = &cobra.Command{
+	Use:   "fuzz",
+	Short: "Mutate statements and save the mutants that oracles disagree about",
+	Long: "Drop, duplicate, swap, and replace the keywords of tokens in a sample of the\n" +
+		"corpus' statements, then run each mutant through every oracle. Mutants that\n" +
+		"the oracles disagree about are saved along with each oracle's prediction as\n" +
+		"statements derived from the statement they were mutated from.",
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true // the arguments were fine
+		return fuzz(cmd)
+	},
+}
+
+func init() {
+	flags := fuzzCmd.Flags()
+	flags.String("corpus", "./corpus.db", "path to the sqlite corpus database")
+	flags.StringSlice("oracles", []string{"pg_query", "raw"}, "which oracles to compare; at least two")
+	flags.String("version", "13", "which postgres version to run the oracles for")
+	flags.String("language", "pgsql", "which language to run the oracles for")
+	flags.Int("statements", 100, "how many statements to mutate; 0 mutates every statement")
+	flags.Int("mutants", 10, "how many mutants to try per statement")
+	flags.Int64("seed", 0, "seed the random choices to repeat a run; 0 picks a seed and prints it")
+	flags.Duration(
+		"timeout", 0,
+		"how long each oracle may spend on each mutant; 0 disables the deadline.\n"+
+			"Defaults to each oracle's own deadline",
+	)
+	flags.Bool("dry-run", false, "print the disagreements rather than saving them")
+	Command.AddCommand(fuzzCmd)
+}
+
+// a token scanned from a statement that's being mutated
+type fuzzToken struct {
+	text    string
+	keyword bool
+}
+
+func tokenizeForFuzzing(text string) ([]fuzzToken, error) {
+	result, err := pg_query.Scan(text)
+	if err != nil {
+		return nil, err
+	}
+	tokens := make([]fuzzToken, len(result.Tokens))
+	for i, token := range result.Tokens {
+		tokens[i] = fuzzToken{
+			text:    text[token.Start:token.End],
+			keyword: token.KeywordKind != pg_query.KeywordKind_NO_KEYWORD,
+		}
+	}
+	return tokens, nil
+}
+
+// a mutation changes a copy of the tokens, or reports that it can't.
+type mutation func(rng *rand.Rand, tokens []fuzzToken, keywords []string) ([]fuzzToken, bool)
+
+var mutations = []struct {
+	name   string
+	mutate mutation
+}{
+	{"drop", func(rng *rand.Rand, tokens []fuzzToken, _ []string) ([]fuzzToken, bool) {
+		if len(tokens) < 2 {
+			return nil, false
+		}
+		i := rng.Intn(len(tokens))
+		return append(append([]fuzzToken{}, tokens[:i]...), tokens[i+1:]...), true
+	}},
+	{"duplicate", func(rng *rand.Rand, tokens []fuzzToken, _ []string) ([]fuzzToken, bool) {
+		i := rng.Intn(len(tokens))
+		return append(append([]fuzzToken{}, tokens[:i+1]...), tokens[i:]...), true
+	}},
+	{"swap", func(rng *rand.Rand, tokens []fuzzToken, _ []string) ([]fuzzToken, bool) {
+		i, j := rng.Intn(len(tokens)), rng.Intn(len(tokens))
+		if tokens[i].text == tokens[j].text {
+			return nil, false
+		}
+		mutant := append([]fuzzToken{}, tokens...)
+		mutant[i], mutant[j] = mutant[j], mutant[i]
+		return mutant, true
+	}},
+	{"keyword", func(rng *rand.Rand, tokens []fuzzToken, keywords []string) ([]fuzzToken, bool) {
+		var candidates []int
+		for i, token := range tokens {
+			if token.keyword {
+				candidates = append(candidates, i)
+			}
+		}
+		if len(candidates) == 0 || len(keywords) < 2 {
+			return nil, false
+		}
+		i := candidates[rng.Intn(len(candidates))]
+		replacement := keywords[rng.Intn(len(keywords))]
+		if strings.EqualFold(replacement, tokens[i].text) {
+			return nil, false
+		}
+		mutant := append([]fuzzToken{}, tokens...)
+		mutant[i] = fuzzToken{text: replacement, keyword: true}
+		return mutant, true
+	}},
+}
+
+// mutate applies a random mutation, returning the mutant's text and the
+// mutation's name.
+func mutate(rng *rand.Rand, tokens []fuzzToken, keywords []string) (string, string, bool) {
+	for attempt := 0; attempt < 10; attempt++ {
+		m := mutations[rng.Intn(len(mutations))]
+		mutant, ok := m.mutate(rng, tokens, keywords)
+		if !ok {
+			continue
+		}
+		texts := make([]string, len(mutant))
+		for i, token := range mutant {
+			texts[i] = token.text
+		}
+		return strings.Join(texts, " "), m.name, true
+	}
+	return "", "", false
+}
+
+// sampleStatements reads up to n statements in the language, starting from a
+// random id and wrapping around.
+func sampleStatements(ctx context.Context, db *sql.DB, languageId int64, n int, start int64) ([]*corpus.Statement, error) {
+	var sample []*corpus.Statement
+	before := start - 1
+	for _, filter := range []corpus.StatementFilter{
+		{LanguageId: &languageId, MinId: &start},
+		{LanguageId: &languageId, MaxId: &before},
+	} {
+		statements := corpus.IterateStatements(ctx, db, filter)
+		for (n <= 0 || len(sample) < n) && statements.Next() {
+			sample = append(sample, statements.Statement())
+		}
+		statements.Close()
+		if err := statements.Err(); err != nil {
+			return nil, err
+		}
+		if start == math.MinInt64 {
+			break // the first pass read every statement
+		}
+	}
+	return sample, nil
+}
+
+// whether any two of the predictions disagree about whether the syntax is valid
+func disagree(predictions []*corpus.Prediction) bool {
+	var first *bool
+	for _, prediction := range predictions {
+		valid := prediction.Valid()
+		if valid == nil {
+			continue
+		}
+		if first == nil {
+			first = valid
+		} else if *first != *valid {
+			return true
+		}
+	}
+	return false
+}
+
+func fuzz(cmd *cobra.Command) error {
+	flags := cmd.Flags()
+	path, _ := flags.GetString("corpus")
+	names, _ := flags.GetStringSlice("oracles")
+	version, _ := flags.GetString("version")
+	language, _ := flags.GetString("language")
+	nStatements, _ := flags.GetInt("statements")
+	nMutants, _ := flags.GetInt("mutants")
+	seed, _ := flags.GetInt64("seed")
+	dryRun, _ := flags.GetBool("dry-run")
+
+	cells, skipped, err := registry.Plan(names, []string{version}, []string{language})
+	if err != nil {
+		return err
+	}
+	if len(skipped) > 0 {
+		return fmt.Errorf("can't run %s: %w", skipped[0].Cell, skipped[0].Reason)
+	}
+	if len(cells) < 2 {
+		return fmt.Errorf("fuzzing needs at least two oracles to compare")
+	}
+	db, err := corpus.ConnectToExisting(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := cmd.Context()
+	lang, err := corpus.LookupLanguage(ctx, db, language)
+	if err != nil {
+		return err
+	}
+	languageId := lang.Id
+	var running []oracles.Oracle
+	var timeouts []time.Duration
+	for _, cell := range cells {
+		oracle, err := cell.Factory.New(ctx, language, version)
+		if err != nil {
+			return err
+		}
+		defer closeOracle(oracle)
+		if !dryRun {
+			err := corpus.RegisterOracle(db, oracle.GetId(), oracle.GetName(), oracle.GetMetadata())
+			if err != nil {
+				return err
+			}
+		}
+		running = append(running, oracle)
+		timeout := cell.Factory.Timeout
+		if flags.Changed("timeout") {
+			timeout, _ = flags.GetDuration("timeout")
+		}
+		timeouts = append(timeouts, timeout)
+	}
+
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	fmt.Println("seed", seed)
+	rng := rand.New(rand.NewSource(seed))
+	sample, err := sampleStatements(ctx, db, languageId, nStatements, int64(rng.Uint64()))
+	if err != nil {
+		return err
+	}
+	// replacement keywords come from the sampled statements
+	tokenized := make([][]fuzzToken, len(sample))
+	seen := map[string]bool{}
+	var keywords []string
+	for i, statement := range sample {
+		tokens, err := tokenizeForFuzzing(statement.Text)
+		if err != nil || len(tokens) == 0 {
+			continue // e.g. an unterminated string
+		}
+		tokenized[i] = tokens
+		for _, token := range tokens {
+			keyword := strings.ToUpper(token.text)
+			if token.keyword && !seen[keyword] {
+				seen[keyword] = true
+				keywords = append(keywords, keyword)
+			}
+		}
+	}
+	sort.Strings(keywords)
+
+	predict := func(text string) ([]*corpus.Prediction, error) {
+		statement := &corpus.Statement{Id: corpus.HashId(text), Text: text}
+		predictions := make([]*corpus.Prediction, len(running))
+		for i, oracle := range running {
+			prediction, err := predictWithin(ctx, timeouts[i], oracle, statement, languageId)
+			if err == nil {
+				err = ctx.Err()
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s predicting %q: %w", oracle.GetName(), text, err)
+			}
+			prediction.OracleId = oracle.GetId()
+			prediction.LanguageId = languageId
+			predictions[i] = prediction
+		}
+		return predictions, nil
+	}
+
+	tried, disagreements := 0, 0
+	for i, statement := range sample {
+		if tokenized[i] == nil {
+			continue
+		}
+		tested := map[string]bool{statement.Text: true}
+		for j := 0; j < nMutants; j++ {
+			text, kind, ok := mutate(rng, tokenized[i], keywords)
+			if !ok || tested[text] {
+				continue
+			}
+			tested[text] = true
+			tried++
+			predictions, err := predict(text)
+			if err != nil {
+				return err
+			}
+			if !disagree(predictions) {
+				continue
+			}
+			disagreements++
+			fmt.Printf("%s mutant of %x: %s\n", kind, uint64(statement.Id), text)
+			for k, prediction := range predictions {
+				fmt.Printf("  %s: %s\n", running[k].GetName(), prediction.Outcome)
+			}
+			if dryRun {
+				continue
+			}
+			id, err := corpus.AddDerivedStatement(ctx, db, &corpus.DerivedStatement{
+				ParentId:   statement.Id,
+				Text:       text,
+				Derivation: corpus.DerivationMutated,
+			})
+			if err != nil {
+				return err
+			}
+			for _, prediction := range predictions {
+				prediction.StatementId = id
+				if err := corpus.InsertPrediction(db, prediction); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	fmt.Printf("tried %d mutants of %d statements; the oracles disagreed about %d\n", tried, len(sample), disagreements)
+	return nil
+}
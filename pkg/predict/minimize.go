@@ -0,0 +1,359 @@
+package predict
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	pg_query "github.com/pganalyze/pg_query_go/v2"
+	"github.com/skalt/pg_sql_tests/pkg/corpus"
+	"github.com/skalt/pg_sql_tests/pkg/oracles"
+	"github.com/skalt/pg_sql_tests/pkg/oracles/registry"
+	"github.com/spf13/cobra"
+)
+
+var minimizeCmd = &cobra.Command{
+	Use:   "minimize STATEMENT_ID",
+	Short: "Shrink a statement an oracle rejects to a minimal reproducer",
+	Long: "Repeatedly drop tokens from a statement the oracle says has invalid syntax\n" +
+		"while the oracle's outcome, SQLSTATE, and the token the error is reported at\n" +
+		"stay the same, then save what's left as a statement derived from the original.\n" +
+		"STATEMENT_ID is hexadecimal, as printed by the other commands.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true // the arguments were fine
+		return minimize(cmd, args[0])
+	},
+}
+
+func init() {
+	flags := minimizeCmd.Flags()
+	flags.String("corpus", "./corpus.db", "path to the sqlite corpus database")
+	flags.String("oracle", "raw", "which oracle's verdict to keep")
+	flags.String("version", "14", "which postgres version to run the oracle for")
+	flags.String("language", "pgsql", "which language to run the oracle for")
+	flags.Duration(
+		"timeout", 0,
+		"how long the oracle may spend on each attempt; 0 disables the deadline.\n"+
+			"Defaults to the oracle's own deadline",
+	)
+	flags.Int("max-tests", 0, "stop shrinking after running the oracle this many times; 0 means no limit")
+	flags.Bool(
+		"same-message", false,
+		"also keep the whole error message, not just the token a syntax error is reported at",
+	)
+	flags.Bool("dry-run", false, "print the reproducer rather than saving it")
+	Command.AddCommand(minimizeCmd)
+}
+
+func minimize(cmd *cobra.Command, hexId string) error {
+	flags := cmd.Flags()
+	path, _ := flags.GetString("corpus")
+	name, _ := flags.GetString("oracle")
+	version, _ := flags.GetString("version")
+	language, _ := flags.GetString("language")
+	maxTests, _ := flags.GetInt("max-tests")
+	sameMessage, _ := flags.GetBool("same-message")
+	dryRun, _ := flags.GetBool("dry-run")
+
+	id, err := strconv.ParseUint(hexId, 16, 64)
+	if err != nil {
+		return fmt.Errorf("invalid statement id %s: %w", hexId, err)
+	}
+	factory, ok := registry.Lookup(name)
+	if !ok {
+		return fmt.Errorf("unknown oracle %s", name)
+	}
+	if err := factory.Check(language, version); err != nil {
+		return fmt.Errorf("oracle %s: %w", name, err)
+	}
+	timeout := factory.Timeout
+	if flags.Changed("timeout") {
+		timeout, _ = flags.GetDuration("timeout")
+	}
+	db, err := corpus.ConnectToExisting(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := cmd.Context()
+	lang, err := corpus.LookupLanguage(ctx, db, language)
+	if err != nil {
+		return err
+	}
+	statementId := int64(id)
+	statements := corpus.IterateStatements(ctx, db, corpus.StatementFilter{MinId: &statementId, MaxId: &statementId})
+	defer statements.Close()
+	if !statements.Next() {
+		if err := statements.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("no statement with id %s", hexId)
+	}
+	statement := statements.Statement()
+	statements.Close()
+
+	oracle, err := factory.New(ctx, language, version)
+	if err != nil {
+		return err
+	}
+	defer closeOracle(oracle)
+	m := minimizer{
+		ctx:         ctx,
+		oracle:      oracle,
+		languageId:  lang.Id,
+		timeout:     timeout,
+		sameMessage: sameMessage,
+		maxTests:    maxTests,
+		tested:      map[string]*corpus.Prediction{},
+	}
+	if err := m.init(statement.Text); err != nil {
+		return err
+	}
+	fmt.Printf("%s says %s; shrinking %d tokens\n", oracle.GetName(), m.target, len(m.tokens))
+	keep, err := m.minimize()
+	if err != nil {
+		return err
+	}
+	if m.exhausted {
+		fmt.Printf("stopped after %d tests\n", m.tests)
+	}
+	reproducer := m.render(keep)
+	fmt.Printf("kept %d of %d tokens after %d tests:\n%s\n", len(keep), len(m.tokens), m.tests, reproducer)
+	if reproducer == statement.Text {
+		fmt.Println("the statement is already minimal")
+		return nil
+	}
+	if dryRun {
+		return nil
+	}
+
+	oracleId := oracle.GetId()
+	if err := corpus.RegisterOracle(db, oracleId, oracle.GetName(), oracle.GetMetadata()); err != nil {
+		return err
+	}
+	derivedId, err := corpus.AddDerivedStatement(ctx, db, &corpus.DerivedStatement{
+		ParentId:   statement.Id,
+		Text:       reproducer,
+		Derivation: corpus.DerivationMinimized,
+		OracleId:   &oracleId,
+	})
+	if err != nil {
+		return err
+	}
+	prediction := m.tested[reproducer]
+	prediction.StatementId = derivedId
+	prediction.OracleId = oracleId
+	prediction.LanguageId = m.languageId
+	if err := corpus.InsertPrediction(db, prediction); err != nil {
+		return err
+	}
+	fmt.Printf("saved %x as minimized from %x\n", uint64(derivedId), uint64(statement.Id))
+	return nil
+}
+
+// a verdict is what minimizing a statement keeps: the outcome, the SQLSTATE
+// for oracles that report one, and for syntax errors the token the error is
+// reported at. Every syntax error has the same outcome and SQLSTATE, so without
+// the token any junk would reproduce one.
+type verdict struct {
+	outcome corpus.Outcome
+	code    string
+	near    string // e.g. `at or near "ONLY"` or `at end of input`
+	message string // only compared with --same-message
+}
+
+func (v verdict) String() string {
+	s := string(v.outcome)
+	if v.code != "" {
+		s += fmt.Sprintf(" (%s)", v.code)
+	}
+	if v.near != "" {
+		s += " " + v.near
+	}
+	return s
+}
+
+// where postgres reports a syntax error, e.g. `syntax error at or near "ONLY"`
+var errorLocation = regexp.MustCompile(`at or near "[^\n]*"|at end of input`)
+
+// reportedError finds the SQLSTATE and message of a prediction's error: the
+// driver oracle serializes a *pq.Error into Error, and pg_query reports SQL
+// syntax errors in the JSON in Message. Other oracles' errors are taken whole.
+func reportedError(prediction *corpus.Prediction) (code string, message string) {
+	var reported struct{ Code, Message string }
+	if err := json.Unmarshal([]byte(prediction.Error), &reported); err == nil && reported.Message != "" {
+		return reported.Code, reported.Message
+	}
+	var scanned struct{ Error *string }
+	if prediction.Error == "" {
+		if err := json.Unmarshal([]byte(prediction.Message), &scanned); err == nil && scanned.Error != nil {
+			return "", *scanned.Error
+		}
+	}
+	return "", prediction.Error
+}
+
+func getVerdict(prediction *corpus.Prediction, sameMessage bool) verdict {
+	code, message := reportedError(prediction)
+	v := verdict{outcome: prediction.Outcome, code: code}
+	if prediction.Outcome == corpus.OutcomeSyntaxError {
+		v.near = errorLocation.FindString(message)
+	}
+	if sameMessage {
+		v.message = message
+	}
+	return v
+}
+
+type span struct{ start, end int32 }
+
+type minimizer struct {
+	ctx         context.Context
+	oracle      oracles.Oracle
+	languageId  int64
+	timeout     time.Duration
+	sameMessage bool
+	maxTests    int
+
+	text   string
+	tokens []span
+	target verdict
+	// the oracle's prediction on each text tried that reproduced the target,
+	// or nil if it didn't
+	tested map[string]*corpus.Prediction
+	tests  int
+	// whether the minimizer stopped early because of maxTests
+	exhausted bool
+}
+
+// init tokenizes the text and takes the oracle's verdict on it as the target.
+func (m *minimizer) init(text string) error {
+	result, err := pg_query.Scan(text)
+	if err != nil {
+		return fmt.Errorf("tokenizing the statement: %w", err)
+	}
+	m.text = text
+	for _, token := range result.Tokens {
+		m.tokens = append(m.tokens, span{token.Start, token.End})
+	}
+	if len(m.tokens) == 0 {
+		return fmt.Errorf("the statement has no tokens")
+	}
+	prediction, err := m.predict(text)
+	if err != nil {
+		return err
+	}
+	m.target = getVerdict(prediction, m.sameMessage)
+	// only syntax errors are worth reproducing: shrinking a timeout or a crash
+	// would chase the oracle's flakiness rather than the grammar
+	if valid := m.target.outcome.Validity(); valid == nil || *valid {
+		return fmt.Errorf("%s says %s; only statements with invalid syntax can be minimized", m.oracle.GetName(), m.target)
+	}
+	all := make([]int, len(m.tokens))
+	for i := range all {
+		all[i] = i
+	}
+	if ok, err := m.reproduces(all); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("the statement's tokens without its comments don't reproduce %s", m.target)
+	}
+	return nil
+}
+
+func (m *minimizer) predict(text string) (*corpus.Prediction, error) {
+	statement := &corpus.Statement{Id: corpus.HashId(text), Text: text}
+	prediction, err := predictWithin(m.ctx, m.timeout, m.oracle, statement, m.languageId)
+	if err == nil {
+		err = m.ctx.Err()
+	}
+	return prediction, err
+}
+
+// render joins the kept tokens, keeping the original text between adjacent
+// tokens and putting a space where tokens were dropped.
+func (m *minimizer) render(keep []int) string {
+	var text strings.Builder
+	for i, index := range keep {
+		token := m.tokens[index]
+		if i > 0 {
+			if keep[i-1] == index-1 {
+				text.WriteString(m.text[m.tokens[index-1].end:token.start])
+			} else {
+				text.WriteByte(' ')
+			}
+		}
+		text.WriteString(m.text[token.start:token.end])
+	}
+	return text.String()
+}
+
+func (m *minimizer) reproduces(keep []int) (bool, error) {
+	text := m.render(keep)
+	if prediction, seen := m.tested[text]; seen {
+		return prediction != nil, nil
+	}
+	if m.maxTests > 0 && m.tests >= m.maxTests {
+		m.exhausted = true
+		return false, nil
+	}
+	m.tests++
+	prediction, err := m.predict(text)
+	if err != nil {
+		return false, err
+	}
+	if getVerdict(prediction, m.sameMessage) != m.target {
+		prediction = nil
+	}
+	m.tested[text] = prediction
+	return prediction != nil, nil
+}
+
+// minimize runs delta debugging over the tokens: it tries dropping each of n
+// chunks of the remaining tokens, halving the chunks whenever none can be
+// dropped, until no single token can be dropped.
+func (m *minimizer) minimize() ([]int, error) {
+	keep := make([]int, len(m.tokens))
+	for i := range keep {
+		keep[i] = i
+	}
+	n := 2
+	for len(keep) > 1 && !m.exhausted {
+		size := (len(keep) + n - 1) / n
+		reduced := false
+		for start := 0; start < len(keep) && !m.exhausted; start += size {
+			end := start + size
+			if end > len(keep) {
+				end = len(keep)
+			}
+			complement := append(append([]int{}, keep[:start]...), keep[end:]...)
+			ok, err := m.reproduces(complement)
+			if err != nil {
+				return keep, err
+			}
+			if ok {
+				keep, reduced = complement, true
+				if n > 2 {
+					n--
+				}
+				break
+			}
+		}
+		if !reduced {
+			if n >= len(keep) {
+				break
+			}
+			n *= 2
+			if n > len(keep) {
+				n = len(keep)
+			}
+		}
+	}
+	return keep, nil
+}
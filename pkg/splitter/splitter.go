@@ -0,0 +1,427 @@
+// Package splitter splits psql scripts into statements the way psql would
+// send them to the server. It understands quoting, nested comments, backslash
+// meta-commands, and the data blocks following `COPY ... FROM stdin`.
+//
+// Statements tile the script: each statement includes the whitespace and
+// comments before it, and any trailing whitespace or comments at the end of
+// the script belong to the last statement. Concatenating the statements'
+// Text reproduces the script exactly.
+package splitter
+
+import (
+	"regexp"
+	"strings"
+)
+
+// a Statement is one statement and its location in the script. Offsets are
+// 0-indexed and count bytes; End is exclusive. Lines are 1-indexed, and like
+// the corpus' document_statements, EndLine is StartLine plus the number of
+// newlines in Text, so it's the next statement's StartLine.
+type Statement struct {
+	Text      string
+	Start     int
+	End       int
+	StartLine int
+	EndLine   int
+	// whether the statement only makes sense to psql, e.g. because it's a
+	// meta-command, interpolates a psql variable, or carries COPY data
+	Psql bool
+}
+
+type Options struct {
+	// whether backslashes in plain '...' strings are literal when the script
+	// starts. The splitter follows `SET standard_conforming_strings` and
+	// `RESET standard_conforming_strings` statements from there.
+	StandardConformingStrings bool
+}
+
+// Split splits a psql script assuming standard_conforming_strings is on, as it
+// is by default since postgres 9.1.
+func Split(script string) []Statement {
+	return SplitWithOptions(script, Options{StandardConformingStrings: true})
+}
+
+func SplitWithOptions(script string, options Options) []Statement {
+	s := scanner{text: script, standardStrings: options.StandardConformingStrings}
+	result := []Statement{}
+	line := 1
+	for s.pos < len(script) {
+		start := s.pos
+		bodyStart, psql := s.statement()
+		text := script[start:s.pos]
+		if bodyStart == s.pos && len(result) > 0 {
+			// only whitespace and comments are left; keep them with the last statement
+			last := &result[len(result)-1]
+			last.Text += text
+			last.End = s.pos
+			last.EndLine = last.StartLine + strings.Count(last.Text, "\n")
+			break
+		}
+		statement := Statement{
+			Text:      text,
+			Start:     start,
+			End:       s.pos,
+			StartLine: line,
+			EndLine:   line + strings.Count(text, "\n"),
+			Psql:      psql,
+		}
+		result = append(result, statement)
+		line = statement.EndLine
+		if !psql {
+			s.track(script[bodyStart:s.pos])
+		}
+	}
+	return result
+}
+
+type scanner struct {
+	text            string
+	pos             int
+	standardStrings bool
+}
+
+var (
+	setStandardStrings = regexp.MustCompile(
+		`(?is)^set\s+(?:session\s+|local\s+)?standard_conforming_strings\s*(?:=|to)\s*'?(on|off|true|false|yes|no|1|0)'?\s*;$`)
+	resetStandardStrings = regexp.MustCompile(`(?is)^reset\s+(?:standard_conforming_strings|all)\s*;$`)
+	copyFromStdin        = regexp.MustCompile(`(?is)^\\?copy\b.*\bfrom\s+stdin\b`)
+	// meta-commands that send the query buffer to the server
+	sendsBuffer = regexp.MustCompile(`^\\(?:g|gx|gset|gexec|gdesc|crosstabview|watch)\b`)
+)
+
+// track follows changes to standard_conforming_strings.
+func (s *scanner) track(statement string) {
+	statement = strings.TrimSpace(statement)
+	if match := setStandardStrings.FindStringSubmatch(statement); match != nil {
+		switch strings.ToLower(match[1]) {
+		case "on", "true", "yes", "1":
+			s.standardStrings = true
+		default:
+			s.standardStrings = false
+		}
+	} else if resetStandardStrings.MatchString(statement) {
+		s.standardStrings = true
+	}
+}
+
+func (s *scanner) peek(offset int) byte {
+	if s.pos+offset < len(s.text) {
+		return s.text[s.pos+offset]
+	}
+	return 0
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9') || c == '$'
+}
+
+// trivia skips whitespace and comments.
+func (s *scanner) trivia() {
+	for s.pos < len(s.text) {
+		switch c := s.peek(0); {
+		case isSpace(c):
+			s.pos++
+		case c == '-' && s.peek(1) == '-':
+			s.lineComment()
+		case c == '/' && s.peek(1) == '*':
+			s.blockComment()
+		default:
+			return
+		}
+	}
+}
+
+func (s *scanner) lineComment() {
+	if end := strings.IndexByte(s.text[s.pos:], '\n'); end >= 0 {
+		s.pos += end + 1
+	} else {
+		s.pos = len(s.text)
+	}
+}
+
+// blockComment skips a possibly-nested /* comment */.
+func (s *scanner) blockComment() {
+	depth := 0
+	for s.pos < len(s.text) {
+		if s.peek(0) == '/' && s.peek(1) == '*' {
+			depth++
+			s.pos += 2
+		} else if s.peek(0) == '*' && s.peek(1) == '/' {
+			depth--
+			s.pos += 2
+			if depth == 0 {
+				return
+			}
+		} else {
+			s.pos++
+		}
+	}
+}
+
+// quoted skips a quoted string or identifier starting at the opening quote.
+// Doubled quotes are escapes, as are backslashes if `backslashes` is set.
+func (s *scanner) quoted(quote byte, backslashes bool) {
+	s.pos++
+	for s.pos < len(s.text) {
+		c := s.peek(0)
+		switch {
+		case backslashes && c == '\\':
+			s.pos += 2
+		case c == quote && s.peek(1) == quote:
+			s.pos += 2
+		case c == quote:
+			s.pos++
+			return
+		default:
+			s.pos++
+		}
+	}
+	if s.pos > len(s.text) {
+		s.pos = len(s.text)
+	}
+}
+
+// dollarQuoted skips a $tag$ ... $tag$ string if one starts here.
+func (s *scanner) dollarQuoted() bool {
+	end := s.pos + 1
+	if end < len(s.text) && isIdentStart(s.text[end]) {
+		for end < len(s.text) && isIdentChar(s.text[end]) && s.text[end] != '$' {
+			end++
+		}
+	}
+	if end >= len(s.text) || s.text[end] != '$' {
+		return false
+	}
+	tag := s.text[s.pos : end+1]
+	if close := strings.Index(s.text[end+1:], tag); close >= 0 {
+		s.pos = end + 1 + close + len(tag)
+	} else {
+		s.pos = len(s.text)
+	}
+	return true
+}
+
+func (s *scanner) word() string {
+	start := s.pos
+	for s.pos < len(s.text) && isIdentChar(s.text[s.pos]) {
+		s.pos++
+	}
+	return s.text[start:s.pos]
+}
+
+// metaCommand skips a backslash command and its arguments, which run until
+// the end of the line or a `\\` separator.
+func (s *scanner) metaCommand() {
+	s.pos++ // the backslash
+	for s.pos < len(s.text) {
+		c := s.peek(0)
+		switch {
+		case c == '\n':
+			return
+		case c == '\\' && s.peek(1) == '\\':
+			s.pos += 2
+			return
+		case c == '\'' || c == '`' || c == '"':
+			// quoted arguments can't span lines
+			s.pos++
+			for s.pos < len(s.text) && s.peek(0) != c && s.peek(0) != '\n' {
+				if c == '\'' && s.peek(0) == '\\' {
+					s.pos++
+				}
+				s.pos++
+			}
+			if s.peek(0) == c {
+				s.pos++
+			}
+		default:
+			s.pos++
+		}
+	}
+	if s.pos > len(s.text) {
+		s.pos = len(s.text)
+	}
+}
+
+// copyData skips the rest of the line after a COPY ... FROM stdin, then the
+// data up to and including the `\.` line that ends it.
+func (s *scanner) copyData() {
+	for s.pos < len(s.text) {
+		end := strings.IndexByte(s.text[s.pos:], '\n')
+		if end < 0 {
+			s.pos = len(s.text)
+			return
+		}
+		s.pos += end + 1
+		next := s.text[s.pos:]
+		lineEnd := strings.IndexByte(next, '\n')
+		if lineEnd < 0 {
+			lineEnd = len(next)
+		}
+		if strings.TrimSuffix(next[:lineEnd], "\r") == `\.` {
+			s.pos += lineEnd
+			if s.pos < len(s.text) {
+				s.pos++ // the newline
+			}
+			return
+		}
+	}
+}
+
+// variable skips a psql variable reference like :name, :'name', or :"name"
+// if one starts here.
+func (s *scanner) variable(brackets int) bool {
+	next := s.peek(1)
+	switch {
+	case next == '\'' || next == '"':
+		s.pos++
+		s.quoted(next, false)
+		return true
+	case next == '{' && s.peek(2) == '?':
+		s.pos += 3
+		s.word()
+		if s.peek(0) == '}' {
+			s.pos++
+		}
+		return true
+	case isIdentStart(next) && brackets == 0 && (s.pos == 0 || !isIdentChar(s.text[s.pos-1])):
+		// inside brackets, `a[1:n]` is an array slice
+		s.pos++
+		s.word()
+		return true
+	}
+	return false
+}
+
+// statement advances past the next statement, returning where its text starts
+// after any leading whitespace and comments, and whether it's psql-specific.
+func (s *scanner) statement() (bodyStart int, psql bool) {
+	s.trivia()
+	bodyStart = s.pos
+	if s.pos >= len(s.text) {
+		return bodyStart, false
+	}
+	if s.peek(0) == '\\' {
+		s.metaCommand()
+		if copyFromStdin.MatchString(s.text[bodyStart:s.pos]) {
+			s.copyData()
+		}
+		return bodyStart, true
+	}
+	parens, brackets, blocks := 0, 0, 0
+	words := []string{} // the first few keywords, to recognize BEGIN ATOMIC bodies
+	for s.pos < len(s.text) {
+		c := s.peek(0)
+		switch {
+		case c == '-' && s.peek(1) == '-':
+			s.lineComment()
+		case c == '/' && s.peek(1) == '*':
+			s.blockComment()
+		case c == '\'':
+			s.quoted('\'', !s.standardStrings)
+		case c == '"':
+			s.quoted('"', false)
+		case c == '$':
+			if !s.dollarQuoted() {
+				s.pos++ // e.g. a $1 parameter
+			}
+		case c == '(':
+			parens++
+			s.pos++
+		case c == ')':
+			if parens > 0 {
+				parens--
+			}
+			s.pos++
+		case c == '[':
+			brackets++
+			s.pos++
+		case c == ']':
+			if brackets > 0 {
+				brackets--
+			}
+			s.pos++
+		case c == ':' && (s.peek(1) == ':' || s.peek(1) == '='):
+			s.pos += 2 // a cast or named argument
+		case c == ':':
+			if s.variable(brackets) {
+				psql = true
+			} else {
+				s.pos++
+			}
+		case c == '\\':
+			start := s.pos
+			s.metaCommand()
+			psql = true
+			if sendsBuffer.MatchString(s.text[start:s.pos]) {
+				return bodyStart, psql
+			}
+		case c == ';':
+			s.pos++
+			if parens == 0 && blocks == 0 {
+				if copyFromStdin.MatchString(s.text[bodyStart:s.pos]) {
+					s.copyData()
+					psql = true
+				}
+				return bodyStart, psql
+			}
+		case isIdentStart(c):
+			word := s.word()
+			if s.peek(0) == '\'' {
+				switch strings.ToLower(word) {
+				case "e":
+					s.quoted('\'', true)
+					continue
+				case "b", "x", "n":
+					s.quoted('\'', !s.standardStrings)
+					continue
+				}
+			} else if s.peek(0) == '&' && strings.EqualFold(word, "u") && (s.peek(1) == '\'' || s.peek(1) == '"') {
+				s.pos++
+				s.quoted(s.peek(0), false)
+				continue
+			}
+			upper := strings.ToUpper(word)
+			if len(words) < 4 {
+				words = append(words, upper)
+			}
+			if isRoutine(words) {
+				switch upper {
+				case "BEGIN", "CASE":
+					blocks++
+				case "END":
+					if blocks > 0 {
+						blocks--
+					}
+				}
+			}
+		case c >= '0' && c <= '9':
+			for s.pos < len(s.text) && (isIdentChar(s.text[s.pos]) || s.text[s.pos] == '.') && s.text[s.pos] != '$' {
+				s.pos++
+			}
+		default:
+			s.pos++
+		}
+	}
+	return bodyStart, psql
+}
+
+// isRoutine recognizes CREATE [OR REPLACE] FUNCTION|PROCEDURE, whose
+// BEGIN ATOMIC ... END bodies contain semicolons.
+func isRoutine(words []string) bool {
+	if len(words) < 2 || words[0] != "CREATE" {
+		return false
+	}
+	kind := words[1]
+	if kind == "OR" && len(words) >= 4 && words[2] == "REPLACE" {
+		kind = words[3]
+	}
+	return kind == "FUNCTION" || kind == "PROCEDURE"
+}
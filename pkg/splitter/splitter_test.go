@@ -0,0 +1,145 @@
+package splitter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// a piece of an expected split: the statement's text and whether it's psql
+type piece struct {
+	text string
+	psql bool
+}
+
+func pieces(statements []Statement) []piece {
+	result := make([]piece, len(statements))
+	for i, statement := range statements {
+		result[i] = piece{statement.Text, statement.Psql}
+	}
+	return result
+}
+
+func TestSplit(t *testing.T) {
+	cases := []struct {
+		name   string
+		script string
+		want   []piece
+	}{
+		{
+			"semicolons",
+			"SELECT 1;\nSELECT 2;\n",
+			[]piece{{"SELECT 1;", false}, {"\nSELECT 2;\n", false}},
+		},
+		{
+			"semicolons in strings and identifiers",
+			`SELECT ';', "a;b", E'\';'; SELECT 2;`,
+			[]piece{{`SELECT ';', "a;b", E'\';';`, false}, {" SELECT 2;", false}},
+		},
+		{
+			"dollar quotes",
+			"DO $$BEGIN PERFORM 1; END$$;\nSELECT $tag$ $$; $tag$;",
+			[]piece{{"DO $$BEGIN PERFORM 1; END$$;", false}, {"\nSELECT $tag$ $$; $tag$;", false}},
+		},
+		{
+			"positional parameters aren't dollar quotes",
+			"SELECT $1; SELECT 2;",
+			[]piece{{"SELECT $1;", false}, {" SELECT 2;", false}},
+		},
+		{
+			"nested comments, with trailing comments kept with the last statement",
+			"/* a /* nested; */ comment; */ SELECT 1; -- trailing; comment\n",
+			[]piece{{"/* a /* nested; */ comment; */ SELECT 1; -- trailing; comment\n", false}},
+		},
+		{
+			"meta-commands",
+			"\\set x 1\nSELECT :x;\n\\echo 'a;b'\n",
+			[]piece{{"\\set x 1", true}, {"\nSELECT :x;", true}, {"\n\\echo 'a;b'\n", true}},
+		},
+		{
+			"meta-commands that send the buffer",
+			"SELECT 1 \\gset\nSELECT 2;",
+			[]piece{{"SELECT 1 \\gset", true}, {"\nSELECT 2;", false}},
+		},
+		{
+			"casts and array slices aren't variables",
+			"SELECT a[1:n]::int[] FROM t;",
+			[]piece{{"SELECT a[1:n]::int[] FROM t;", false}},
+		},
+		{
+			"copy from stdin",
+			"COPY t FROM stdin;\n1\ta;b\n\\.\nSELECT 1;",
+			[]piece{{"COPY t FROM stdin;\n1\ta;b\n\\.\n", true}, {"SELECT 1;", false}},
+		},
+		{
+			"\\copy from stdin",
+			"\\copy t from stdin\n1\n\\.\nSELECT 1;",
+			[]piece{{"\\copy t from stdin\n1\n\\.\n", true}, {"SELECT 1;", false}},
+		},
+		{
+			"begin atomic bodies",
+			"CREATE FUNCTION f() RETURNS int LANGUAGE sql BEGIN ATOMIC SELECT 1; END; SELECT 2;",
+			[]piece{
+				{"CREATE FUNCTION f() RETURNS int LANGUAGE sql BEGIN ATOMIC SELECT 1; END;", false},
+				{" SELECT 2;", false},
+			},
+		},
+		{
+			"an unterminated statement",
+			"SELECT 1; SELECT 'a;",
+			[]piece{{"SELECT 1;", false}, {" SELECT 'a;", false}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := pieces(Split(c.script)); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("Split(%q)\n got %+v\nwant %+v", c.script, got, c.want)
+			}
+		})
+	}
+}
+
+func TestStandardConformingStrings(t *testing.T) {
+	script := "SET standard_conforming_strings = off;\nSELECT 'a\\';b';\nRESET standard_conforming_strings;\nSELECT 'c\\';"
+	want := []piece{
+		{"SET standard_conforming_strings = off;", false},
+		{"\nSELECT 'a\\';b';", false},
+		{"\nRESET standard_conforming_strings;", false},
+		{"\nSELECT 'c\\';", false},
+	}
+	if got := pieces(Split(script)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+	off := pieces(SplitWithOptions("SELECT 'a\\';b';", Options{StandardConformingStrings: false}))
+	if want := []piece{{"SELECT 'a\\';b';", false}}; !reflect.DeepEqual(off, want) {
+		t.Errorf("got %+v\nwant %+v", off, want)
+	}
+}
+
+func TestSpans(t *testing.T) {
+	script := "-- leading\nSELECT 1;\n\nSELECT\n  2;\nCOPY t FROM stdin;\na\n\\.\n-- trailing\n"
+	statements := Split(script)
+	if len(statements) != 3 {
+		t.Fatalf("expected 3 statements, got %d: %+v", len(statements), pieces(statements))
+	}
+	text, line, offset := "", 1, 0
+	for i, statement := range statements {
+		if statement.Start != offset || statement.End != offset+len(statement.Text) {
+			t.Errorf("statement %d: offsets [%d, %d), want [%d, %d)",
+				i, statement.Start, statement.End, offset, offset+len(statement.Text))
+		}
+		if script[statement.Start:statement.End] != statement.Text {
+			t.Errorf("statement %d: %q isn't the script's text at its offsets", i, statement.Text)
+		}
+		wantEndLine := line + strings.Count(statement.Text, "\n")
+		if statement.StartLine != line || statement.EndLine != wantEndLine {
+			t.Errorf("statement %d: lines %d-%d, want %d-%d",
+				i, statement.StartLine, statement.EndLine, line, wantEndLine)
+		}
+		text += statement.Text
+		line, offset = statement.EndLine, statement.End
+	}
+	if text != script {
+		t.Errorf("the statements don't reproduce the script: %q", text)
+	}
+}
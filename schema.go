@@ -0,0 +1,8 @@
+// Package pg_sql_tests embeds the corpus database schema, which lives at the
+// root of the repo so that the rust splitter can include it too.
+package pg_sql_tests
+
+import _ "embed"
+
+//go:embed schema.sql
+var Schema string
@@ -3,23 +3,27 @@ CREATE TABLE schema_version (
   , minor INT4 -- there's a new table or column
   , CONSTRAINT schema_version_pkey PRIMARY KEY (major, minor)
 );
-INSERT INTO schema_version VALUES (0, 0);
+INSERT INTO schema_version VALUES (0, 8);
 
 CREATE TABLE languages (
-    id INTEGER PRIMARY KEY -- TODO: make xxhash(name)? Not worth it for now
+    id INTEGER PRIMARY KEY -- fixed for the languages below; the xxhash of the
+                           -- name for languages added by `corpus languages add`
   , "name" TEXT UNIQUE
+  , family TEXT -- the family of versions the language is tested against, e.g.
+                -- 'postgres', if any
+  , "version" TEXT -- the version of the family the language is pinned to, if any
   -- , CONSTRAINT version_url_id_fkey FOREIGN KEY (url_id) REFERENCES urls.id
 );
 
 INSERT INTO languages VALUES
-    (-1, "other")
-  , (0, "pgsql")
-  , (1, "plpgsql")
-  , (2, "psql")
-  , (3, "plperl")
-  , (4, "pltcl")
-  , (5, "plpython2")
-  , (6, "plpython3");
+    (-1, "other", NULL, NULL)
+  , (0, "pgsql", "postgres", NULL)
+  , (1, "plpgsql", "postgres", NULL)
+  , (2, "psql", "postgres", NULL)
+  , (3, "plperl", "postgres", NULL)
+  , (4, "pltcl", "postgres", NULL)
+  , (5, "plpython2", "postgres", NULL)
+  , (6, "plpython3", "postgres", NULL);
 
 -- for coordinating compatibility:
 CREATE TABLE versions(
@@ -56,7 +60,43 @@ CREATE TABLE statement_fingerprints(
   , CONSTRAINT statement_fingerprints_pkey PRIMARY KEY (statement_id, fingerprint)
 );
 
--- TODO: delete?
+-- the tokens pg_query.Scan finds in each pgsql statement, as recorded by
+-- `corpus tokens`
+CREATE TABLE statement_tokens(
+    statement_id INTEGER REFERENCES statements(id)
+  , ordinal INTEGER -- the token's position in the statement, counting from 0
+  , token TEXT -- pg_query's name for the token, e.g. 'SELECT', 'IDENT', or
+               -- 'ASCII_40' for '('
+  , keyword_kind TEXT -- e.g. 'RESERVED_KEYWORD'. Null unless the token is a keyword
+  , start_offset INTEGER -- in bytes from the start of the statement's text
+  , end_offset INTEGER
+  , CONSTRAINT statement_tokens_pkey PRIMARY KEY (statement_id, ordinal)
+);
+CREATE INDEX statement_tokens_by_token ON statement_tokens(token, statement_id);
+
+-- which grammar constructs each pgsql statement uses, as indexed from its
+-- pg_query parse tree by `corpus nodes`
+CREATE TABLE statement_nodes(
+    statement_id INTEGER REFERENCES statements(id)
+  , node TEXT -- a parse tree node type, e.g. 'SelectStmt' or 'WindowDef'
+  , top_level BOOLEAN -- whether the node is one of the statement's top-level
+                      -- statements, i.e. what the statement is
+  , "count" INTEGER -- how many of the node the parse tree has
+  , CONSTRAINT statement_nodes_pkey PRIMARY KEY (statement_id, node)
+);
+CREATE INDEX statement_nodes_by_node ON statement_nodes(node, top_level, statement_id);
+
+CREATE TABLE statement_options(
+    statement_id INTEGER REFERENCES statements(id)
+  , "option" TEXT -- a set boolean field, e.g. 'RangeSubselect.lateral', a
+                  -- non-default enum field, e.g. 'AlterTableCmd.subtype=AT_AddColumn',
+                  -- or an operator, e.g. 'A_Expr.name=->>'
+  , CONSTRAINT statement_options_pkey PRIMARY KEY (statement_id, "option")
+);
+CREATE INDEX statement_options_by_option ON statement_options("option", statement_id);
+
+-- which versions accept each statement, as derived from their oracles' predictions
+-- by `corpus versions`
 CREATE TABLE statement_versions(
     statement_id INT8 REFERENCES statements(id)
   , version_id INT8 REFERENCES versions(id)
@@ -102,10 +142,19 @@ CREATE TABLE document_statements (
 CREATE INDEX statements_for_source ON document_statements(statement_id, document_id, start_offset);
 
 CREATE TABLE oracles(
-   id INTEGER PRIMARY KEY -- xxhash3_64 of the oracle name
+   id INTEGER PRIMARY KEY -- xxhash64 of the oracle name and its metadata, if any
   , "name" TEXT -- e.g. "postgres 13 no-op do-block".
 );
 
+-- exactly which engine build and configuration an oracle consulted, so that
+-- e.g. predictions from postgres 14.1 and 14.2 don't share an oracle id
+CREATE TABLE oracle_metadata(
+    oracle_id INTEGER REFERENCES oracles(id)
+  , "key" TEXT -- e.g. "server_version", "image_digest", or "guc.<setting name>"
+  , "value" TEXT
+  , CONSTRAINT oracle_metadata_pkey PRIMARY KEY (oracle_id, "key")
+);
+
 CREATE TABLE predictions(
     statement_id INTEGER REFERENCES statements(id)
   , oracle_id INTEGER REFERENCES oracles(id) -- encodes version
@@ -116,8 +165,43 @@ CREATE TABLE predictions(
                    -- json {syntax/parse tree, tokens}. This column is just
                    -- for debugging, so don't sweat it and probably don't try to
                    -- parse it unless you're confident of its structure.
-  , valid BOOLEAN
+  , valid BOOLEAN -- null unless the outcome says whether the syntax is valid
+  , outcome TEXT -- one of 'valid', 'syntax-error', 'semantic-error',
+                 -- 'runtime-error', 'ambiguous', 'timeout', 'oracle-failure',
+                 -- 'server-crash', or 'round-trip-mismatch'. Null for
+                 -- predictions made before 0.1.
+  , inferred_from INTEGER REFERENCES statements(id) -- the statement with the
+                 -- same fingerprint that the oracle actually predicted, if it
+                 -- only predicted one statement per fingerprint. Null otherwise.
   , CONSTRAINT predictions_pkey PRIMARY KEY (statement_id, oracle_id, language_id)
 );
 CREATE INDEX predictions_by_oracle ON predictions(oracle_id, statement_id, language_id);
-CREATE INDEX predictions_by_language ON predictions(language_id, statement_id, oracle_id);
\ No newline at end of file
+CREATE INDEX predictions_by_language ON predictions(language_id, statement_id, oracle_id);
+
+-- predictions that `corpus merge --policy=record-both` set aside because the
+-- corpus merged earlier had a different verdict on the same statement, oracle,
+-- and language
+CREATE TABLE prediction_conflicts(
+    statement_id INTEGER REFERENCES statements(id)
+  , oracle_id INTEGER REFERENCES oracles(id)
+  , language_id INTEGER REFERENCES languages(id)
+  , error TEXT
+  , "message" TEXT
+  , valid BOOLEAN
+  , outcome TEXT
+  , source TEXT -- the path of the corpus the prediction was merged from
+  , inferred_from INTEGER REFERENCES statements(id)
+);
+CREATE INDEX prediction_conflicts_by_prediction ON prediction_conflicts(statement_id, oracle_id, language_id);
+
+-- statements made from other statements, e.g. minimized by `predict minimize` or
+-- mutated by `predict fuzz`
+CREATE TABLE derived_statements(
+    statement_id INTEGER REFERENCES statements(id)
+  , parent_id INTEGER REFERENCES statements(id)
+  , derivation TEXT -- how the statement was made from its parent: 'minimized' or 'mutated'
+  , oracle_id INTEGER REFERENCES oracles(id) -- the oracle whose verdict the
+                                            -- derivation kept, if any
+  , CONSTRAINT derived_statements_pkey PRIMARY KEY (statement_id, parent_id, derivation, oracle_id)
+);
+CREATE INDEX derived_statements_by_parent ON derived_statements(parent_id, statement_id);
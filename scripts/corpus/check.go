@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/skalt/pg_sql_tests/pkg/corpus"
+	"github.com/spf13/cobra"
+)
+
+type problem struct {
+	Check    string `json:"check"`
+	Table    string `json:"table"`
+	Key      string `json:"key"`
+	Detail   string `json:"detail"`
+	Repaired bool   `json:"repaired"`
+}
+
+type checkSummary struct {
+	Check    string     `json:"check"`
+	Count    int        `json:"count"`
+	Repaired int        `json:"repaired"`
+	Examples []*problem `json:"examples"`
+}
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Verify a corpus' invariants, optionally repairing what's broken",
+	Long: "Verify that\n" +
+		"  statement-id  each statement's id is the xxh3_64 of its text\n" +
+		"  span          each document_statements span is as long as its statement's text;\n" +
+		"                documents' texts aren't stored, so only the lengths are compared\n" +
+		"  overlap       the spans within each document don't overlap\n" +
+		"  language      each statement has a language\n" +
+		"  foreign-key   each foreign key refers to an existing row\n" +
+		"With --repair, re-key statements, recompute spans, guess missing languages,\n" +
+		"register placeholders for missing oracles and documents, and delete other rows\n" +
+		"that refer to missing rows. Overlapping spans and statements whose correct id\n" +
+		"belongs to a different text are only reported.\n" +
+		"--format=jsonl prints one problem per line; otherwise problems are summarized\n" +
+		"per check. Exits non-zero if any problem is left unrepaired.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		format, err := flags.GetString("format")
+		if err != nil {
+			return err
+		}
+		if format != "text" && format != "json" && format != "jsonl" {
+			return fmt.Errorf("--format: expected text, json, or jsonl, got %s", format)
+		}
+		repair, err := flags.GetBool("repair")
+		if err != nil {
+			return err
+		}
+		examples, err := flags.GetInt("examples")
+		if err != nil {
+			return err
+		}
+		db, err := openCorpus(cmd)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		summaries := []*checkSummary{}
+		byCheck := map[string]*checkSummary{}
+		unrepaired := 0
+		encoder := json.NewEncoder(os.Stdout)
+		err = corpus.CheckCorpus(cmd.Context(), db, repair, func(p *corpus.Problem) error {
+			found := problem{p.Check, p.Table, p.Key, p.Detail, p.Repaired}
+			if !found.Repaired {
+				unrepaired++
+			}
+			if format == "jsonl" {
+				return encoder.Encode(&found)
+			}
+			summary, ok := byCheck[found.Check]
+			if !ok {
+				summary = &checkSummary{Check: found.Check, Examples: []*problem{}}
+				byCheck[found.Check] = summary
+				summaries = append(summaries, summary)
+			}
+			summary.Count++
+			if found.Repaired {
+				summary.Repaired++
+			}
+			if examples == 0 || len(summary.Examples) < examples {
+				summary.Examples = append(summary.Examples, &found)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		switch format {
+		case "json":
+			if err := printJson(summaries); err != nil {
+				return err
+			}
+		case "text":
+			if len(summaries) == 0 {
+				fmt.Println("no problems found")
+			}
+			for _, summary := range summaries {
+				fmt.Printf("%s: %d problems, %d repaired\n", summary.Check, summary.Count, summary.Repaired)
+				for _, example := range summary.Examples {
+					fmt.Printf("  %s %s: %s\n", example.Table, example.Key, example.Detail)
+				}
+			}
+		}
+		if unrepaired > 0 {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("%d problems left unrepaired", unrepaired)
+		}
+		return nil
+	},
+}
+
+func init() {
+	flags := checkCmd.Flags()
+	flags.String("corpus", "./corpus.db", "path to the sqlite corpus database")
+	flags.String("format", "text", "text, json, or jsonl")
+	flags.Bool("repair", false, "fix the problems that can be fixed")
+	flags.Int("examples", 3, "how many problems to show per check; 0 shows them all. Ignored by --format=jsonl")
+	rootCmd.AddCommand(checkCmd)
+}
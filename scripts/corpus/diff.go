@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/skalt/pg_sql_tests/pkg/corpus"
+	"github.com/spf13/cobra"
+)
+
+type diffOracle struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func toDiffOracle(oracle *corpus.OracleInfo) *diffOracle {
+	return &diffOracle{fmt.Sprintf("%x", uint64(oracle.Id)), oracle.Name}
+}
+
+type diffStatement struct {
+	StatementId string `json:"statement_id"`
+	Text        string `json:"text"`
+	OldError    string `json:"old_error,omitempty"`
+	NewError    string `json:"new_error,omitempty"`
+}
+
+type diffChange struct {
+	Was      string           `json:"was"`
+	Now      string           `json:"now"`
+	Count    int64            `json:"count"`
+	Examples []*diffStatement `json:"examples"`
+}
+
+type diffPair struct {
+	Old      *diffOracle   `json:"old"`
+	New      *diffOracle   `json:"new"`
+	Compared int64         `json:"compared"`
+	Changes  []*diffChange `json:"changes"`
+}
+
+type diffReport struct {
+	Old               string           `json:"old"`
+	New               string           `json:"new"`
+	AddedStatements   int64            `json:"added_statements"`
+	RemovedStatements int64            `json:"removed_statements"`
+	AddedExamples     []*diffStatement `json:"added_examples"`
+	RemovedExamples   []*diffStatement `json:"removed_examples"`
+	AddedOracles      []*diffOracle    `json:"added_oracles"`
+	RemovedOracles    []*diffOracle    `json:"removed_oracles"`
+	Oracles           []*diffPair      `json:"oracles"`
+	Flips             int64            `json:"flips"`
+}
+
+func toDiffStatements(statements []*corpus.Statement) []*diffStatement {
+	result := make([]*diffStatement, len(statements))
+	for i, statement := range statements {
+		result[i] = &diffStatement{StatementId: fmt.Sprintf("%x", uint64(statement.Id)), Text: statement.Text}
+	}
+	return result
+}
+
+func toDiffReport(oldPath string, newPath string, diff *corpus.CorpusDiff) *diffReport {
+	report := diffReport{
+		Old:               oldPath,
+		New:               newPath,
+		AddedStatements:   diff.AddedStatements,
+		RemovedStatements: diff.RemovedStatements,
+		AddedExamples:     toDiffStatements(diff.AddedExamples),
+		RemovedExamples:   toDiffStatements(diff.RemovedExamples),
+		AddedOracles:      []*diffOracle{},
+		RemovedOracles:    []*diffOracle{},
+		Oracles:           []*diffPair{},
+		Flips:             diff.Flips(),
+	}
+	for _, oracle := range diff.AddedOracles {
+		report.AddedOracles = append(report.AddedOracles, toDiffOracle(oracle))
+	}
+	for _, oracle := range diff.RemovedOracles {
+		report.RemovedOracles = append(report.RemovedOracles, toDiffOracle(oracle))
+	}
+	for _, pair := range diff.Oracles {
+		p := diffPair{
+			Old:      toDiffOracle(pair.Old),
+			New:      toDiffOracle(pair.New),
+			Compared: pair.Compared,
+			Changes:  []*diffChange{},
+		}
+		for _, change := range pair.Changes {
+			c := diffChange{Was: change.Was, Now: change.Now, Count: change.Count, Examples: []*diffStatement{}}
+			for _, example := range change.Examples {
+				c.Examples = append(c.Examples, &diffStatement{
+					StatementId: fmt.Sprintf("%x", uint64(example.StatementId)),
+					Text:        example.Text,
+					OldError:    snippet(example.OldError, 120),
+					NewError:    snippet(example.NewError, 120),
+				})
+			}
+			p.Changes = append(p.Changes, &c)
+		}
+		report.Oracles = append(report.Oracles, &p)
+	}
+	return &report
+}
+
+func printDiff(report *diffReport) {
+	fmt.Printf("%s -> %s\n", report.Old, report.New)
+	fmt.Printf("statements: +%d -%d\n", report.AddedStatements, report.RemovedStatements)
+	for _, example := range report.AddedExamples {
+		fmt.Printf("  + %s %s\n", example.StatementId, snippet(example.Text, 100))
+	}
+	for _, example := range report.RemovedExamples {
+		fmt.Printf("  - %s %s\n", example.StatementId, snippet(example.Text, 100))
+	}
+	for _, oracle := range report.AddedOracles {
+		fmt.Printf("new oracle: %s (%s)\n", oracle.Name, oracle.Id)
+	}
+	for _, oracle := range report.RemovedOracles {
+		fmt.Printf("vanished oracle: %s (%s)\n", oracle.Name, oracle.Id)
+	}
+	for _, pair := range report.Oracles {
+		name := pair.New.Name
+		if pair.Old.Id != pair.New.Id {
+			name = fmt.Sprintf("%s (%s -> %s)", name, pair.Old.Id, pair.New.Id)
+		}
+		if len(pair.Changes) == 0 {
+			fmt.Printf("%s: %d predictions unchanged\n", name, pair.Compared)
+			continue
+		}
+		fmt.Printf("%s: %d predictions compared\n", name, pair.Compared)
+		for _, change := range pair.Changes {
+			fmt.Printf("  %s -> %s: %d\n", change.Was, change.Now, change.Count)
+			for _, example := range change.Examples {
+				fmt.Printf("    %s %s\n", example.StatementId, snippet(example.Text, 100))
+				if example.OldError != "" {
+					fmt.Printf("      was: %s\n", example.OldError)
+				}
+				if example.NewError != "" {
+					fmt.Printf("      now: %s\n", example.NewError)
+				}
+			}
+		}
+	}
+	fmt.Printf("%d predictions flipped between valid and invalid\n", report.Flips)
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff OLD NEW",
+	Short: "Compare two corpus databases",
+	Long: "Compare two corpus databases: which statements were added or removed, which\n" +
+		"oracles are new or vanished, and which predictions changed validity. Oracles are\n" +
+		"compared by id, or by name if exactly one oracle in each corpus has the name, so\n" +
+		"that e.g. bumping an image's version still compares its predictions.\n" +
+		"Exits non-zero if any of the --max-* thresholds are exceeded.",
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := getFormat(cmd)
+		if err != nil {
+			return err
+		}
+		flags := cmd.Flags()
+		examples, err := flags.GetInt("examples")
+		if err != nil {
+			return err
+		}
+		maxFlips, err := flags.GetInt64("max-flips")
+		if err != nil {
+			return err
+		}
+		maxRemoved, err := flags.GetInt64("max-removed")
+		if err != nil {
+			return err
+		}
+		maxVanished, err := flags.GetInt("max-vanished-oracles")
+		if err != nil {
+			return err
+		}
+		oldPath, newPath := args[0], args[1]
+		// both corpora must be readable at the current schema version
+		old, err := corpus.ConnectToExisting(oldPath)
+		if err != nil {
+			return fmt.Errorf("%s: %w", oldPath, err)
+		}
+		old.Close()
+		db, err := corpus.ConnectToExisting(newPath)
+		if err != nil {
+			return fmt.Errorf("%s: %w", newPath, err)
+		}
+		defer db.Close()
+		diff, err := corpus.DiffCorpora(cmd.Context(), db, oldPath, examples)
+		if err != nil {
+			return err
+		}
+		report := toDiffReport(oldPath, newPath, diff)
+		if format == "json" {
+			if err := printJson(report); err != nil {
+				return err
+			}
+		} else {
+			printDiff(report)
+		}
+
+		// the rest are regressions rather than usage errors
+		cmd.SilenceUsage = true
+		if maxFlips >= 0 && report.Flips > maxFlips {
+			return fmt.Errorf("%d flipped predictions exceed --max-flips=%d", report.Flips, maxFlips)
+		}
+		if maxRemoved >= 0 && report.RemovedStatements > maxRemoved {
+			return fmt.Errorf("%d removed statements exceed --max-removed=%d", report.RemovedStatements, maxRemoved)
+		}
+		if maxVanished >= 0 && len(report.RemovedOracles) > maxVanished {
+			return fmt.Errorf(
+				"%d vanished oracles exceed --max-vanished-oracles=%d", len(report.RemovedOracles), maxVanished,
+			)
+		}
+		return nil
+	},
+}
+
+func init() {
+	flags := diffCmd.Flags()
+	flags.String("format", "text", "text or json")
+	flags.Int("examples", 3, "how many statements to show per kind of change; 0 shows them all")
+	flags.Int64("max-flips", -1, "fail if more predictions than this flipped between valid and invalid; -1 never fails")
+	flags.Int64("max-removed", -1, "fail if more statements than this were removed; -1 never fails")
+	flags.Int("max-vanished-oracles", -1, "fail if more oracles than this vanished; -1 never fails")
+	rootCmd.AddCommand(diffCmd)
+}
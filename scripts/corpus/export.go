@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/skalt/pg_sql_tests/pkg/corpus"
+	"github.com/spf13/cobra"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// the shape of each exported record in every format. Ids are hexadecimal
+// strings since javascript can't represent every 64-bit integer.
+type exportRow struct {
+	StatementId string   `json:"statement_id" parquet:"name=statement_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Text        string   `json:"text" parquet:"name=text, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Language    string   `json:"language" parquet:"name=language, type=BYTE_ARRAY, convertedtype=UTF8"`
+	OracleId    *string  `json:"oracle_id" parquet:"name=oracle_id, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	Oracle      *string  `json:"oracle" parquet:"name=oracle, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	Version     *string  `json:"version" parquet:"name=version, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	Outcome     *string  `json:"outcome" parquet:"name=outcome, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	Valid       *bool    `json:"valid" parquet:"name=valid, type=BOOLEAN, repetitiontype=OPTIONAL"`
+	Error       *string  `json:"error" parquet:"name=error, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	Urls        []string `json:"urls" parquet:"name=urls, type=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+	Licenses    []string `json:"licenses" parquet:"name=licenses, type=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+}
+
+var csvHeader = []string{
+	"statement_id", "text", "language", "oracle_id", "oracle", "version",
+	"outcome", "valid", "error", "urls", "licenses",
+}
+
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func toExportRow(record *corpus.ExportRecord) *exportRow {
+	row := exportRow{
+		StatementId: fmt.Sprintf("%x", uint64(record.StatementId)),
+		Text:        record.Text,
+		Language:    record.Language,
+		Oracle:      optional(record.Oracle),
+		Version:     optional(record.Version),
+		Outcome:     optional(string(record.Outcome)),
+		Valid:       record.Valid,
+		Error:       optional(record.Error),
+		Urls:        record.Urls,
+		Licenses:    record.Licenses,
+	}
+	if record.Oracle != "" {
+		row.OracleId = optional(fmt.Sprintf("%x", uint64(record.OracleId)))
+	}
+	return &row
+}
+
+// a recordWriter encodes rows in one of the export formats.
+type recordWriter interface {
+	Write(*exportRow) error
+	Close() error
+}
+
+type jsonlWriter struct {
+	out     *bufio.Writer
+	encoder *json.Encoder
+}
+
+func (w *jsonlWriter) Write(row *exportRow) error { return w.encoder.Encode(row) }
+func (w *jsonlWriter) Close() error               { return w.out.Flush() }
+
+type csvWriter struct{ out *csv.Writer }
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func (w *csvWriter) Write(row *exportRow) error {
+	valid := ""
+	if row.Valid != nil {
+		valid = strconv.FormatBool(*row.Valid)
+	}
+	return w.out.Write([]string{
+		row.StatementId, row.Text, row.Language,
+		deref(row.OracleId), deref(row.Oracle), deref(row.Version),
+		deref(row.Outcome), valid, deref(row.Error),
+		strings.Join(row.Urls, " "), strings.Join(row.Licenses, " "),
+	})
+}
+
+func (w *csvWriter) Close() error {
+	w.out.Flush()
+	return w.out.Error()
+}
+
+type parquetWriter struct{ out *writer.ParquetWriter }
+
+func (w *parquetWriter) Write(row *exportRow) error { return w.out.Write(row) }
+func (w *parquetWriter) Close() error               { return w.out.WriteStop() }
+
+func newRecordWriter(format string, out io.Writer) (recordWriter, error) {
+	switch format {
+	case "jsonl":
+		buffered := bufio.NewWriter(out)
+		return &jsonlWriter{buffered, json.NewEncoder(buffered)}, nil
+	case "csv":
+		w := csv.NewWriter(out)
+		if err := w.Write(csvHeader); err != nil {
+			return nil, err
+		}
+		return &csvWriter{w}, nil
+	case "parquet":
+		w, err := writer.NewParquetWriterFromWriter(out, new(exportRow), 4)
+		if err != nil {
+			return nil, err
+		}
+		w.CompressionType = parquet.CompressionCodec_SNAPPY
+		return &parquetWriter{w}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %s; expected jsonl, csv, or parquet", format)
+	}
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export statements with their predictions, sources, and licenses as JSON lines, CSV, or parquet",
+	Long: "Export statements with their predictions, sources, and licenses. Each record is\n" +
+		"a statement in one of its languages along with one of its predictions; statements\n" +
+		"without predictions get one record with empty prediction fields unless the oracles\n" +
+		"or predictions are filtered.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		format, err := flags.GetString("format")
+		if err != nil {
+			return err
+		}
+		outPath, err := flags.GetString("out")
+		if err != nil {
+			return err
+		}
+		filter := corpus.ExportFilter{}
+		if filter.Oracles, err = flags.GetStringArray("oracle"); err != nil {
+			return err
+		}
+		if filter.Versions, err = flags.GetStringSlice("versions"); err != nil {
+			return err
+		}
+		if filter.Validity, err = flags.GetString("validity"); err != nil {
+			return err
+		}
+		if filter.LicenseId, err = flags.GetString("license"); err != nil {
+			return err
+		}
+		if filter.ConstructFilter, err = getConstructFilter(cmd); err != nil {
+			return err
+		}
+		db, err := openCorpus(cmd)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if language, err := optionalLanguageFlag(cmd, db); err != nil {
+			return err
+		} else if language != nil {
+			filter.LanguageId = &language.Id
+		}
+
+		var out io.Writer = os.Stdout
+		if outPath != "-" {
+			file, err := os.Create(outPath)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			out = file
+		}
+		w, err := newRecordWriter(format, out)
+		if err != nil {
+			return err
+		}
+		err = corpus.EachExportRecord(cmd.Context(), db, filter, func(record *corpus.ExportRecord) error {
+			return w.Write(toExportRow(record))
+		})
+		if err != nil {
+			return err
+		}
+		return w.Close()
+	},
+}
+
+func init() {
+	flags := exportCmd.Flags()
+	flags.String("corpus", "./corpus.db", "path to the sqlite corpus database")
+	flags.String("format", "jsonl", "jsonl, csv, or parquet")
+	flags.StringP("out", "o", "-", "where to write the export; - writes to stdout")
+	flags.String("language", "", "only export statements in this language")
+	flags.StringArray("oracle", nil, "only export predictions by this oracle, named as in the oracles table or by hex id; may be repeated")
+	flags.StringSlice("versions", nil, "only export predictions by oracles for these versions")
+	flags.String("validity", "", "only export predictions that are valid, invalid, or unknown")
+	flags.String("license", "", "only export statements from urls under this license, e.g. PostgreSQL")
+	addConstructFlags(exportCmd)
+	rootCmd.AddCommand(exportCmd)
+}
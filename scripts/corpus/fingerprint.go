@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/skalt/pg_sql_tests/pkg/corpus"
+	"github.com/spf13/cobra"
+)
+
+var fingerprintCmd = &cobra.Command{
+	Use:   "fingerprint",
+	Short: "Fingerprint the pgsql statements that don't have a fingerprint yet",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := openCorpus(cmd)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		added, unparseable, err := corpus.BackfillFingerprints(cmd.Context(), db)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("fingerprinted %d statements; pg_query couldn't parse %d\n", added, unparseable)
+		return nil
+	},
+}
+
+func init() {
+	fingerprintCmd.Flags().String("corpus", "./corpus.db", "path to the sqlite corpus database")
+	rootCmd.AddCommand(fingerprintCmd)
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v2"
+	"github.com/skalt/pg_sql_tests/pkg/corpus"
+	"github.com/skalt/pg_sql_tests/pkg/languages"
+	"github.com/spf13/cobra"
+)
+
+// the postgres version whose parser pg_query_go/v2 bundles
+const pgQueryVersion = "13"
+
+const rw = 0666 // -rw-rw-rw-, like scripts/parse
+
+type fixtureVerdict struct {
+	Oracle   string         `json:"oracle"`
+	OracleId string         `json:"oracle_id"`
+	Outcome  corpus.Outcome `json:"outcome,omitempty"`
+	Valid    *bool          `json:"valid"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// the contents of expected.json
+type fixtureExpectation struct {
+	StatementId string `json:"statement_id"`
+	Language    string `json:"language"`
+	Version     string `json:"version"`
+	DocumentId  string `json:"document_id"`
+	StartLine   int64  `json:"start_line"`
+	EndLine     int64  `json:"end_line"`
+	// whether every verdict with a validity agrees the statement is valid;
+	// null if there are no such verdicts or they disagree
+	Valid    *bool             `json:"valid"`
+	Verdicts []*fixtureVerdict `json:"verdicts"`
+	Urls     []string          `json:"urls"`
+	Licenses []string          `json:"licenses"`
+}
+
+// the same shape as scripts/parse's tokens.json
+type token struct {
+	Name  string
+	Start int32
+	End   int32
+	Text  string
+}
+
+func writeJson(path string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, rw)
+}
+
+// writeParse writes tokens.json and ast.json the way scripts/parse does, or
+// err.txt if pg_query can't lex or parse the statement.
+func writeParse(dir string, text string) error {
+	scanned, err := pg_query.Scan(text)
+	if err != nil {
+		return os.WriteFile(filepath.Join(dir, "err.txt"), []byte(fmt.Sprintf("%v", err)), rw)
+	}
+	tokens := make([]*token, len(scanned.Tokens))
+	for i, t := range scanned.Tokens {
+		tokens[i] = &token{t.Token.String(), t.Start, t.End, text[t.Start:t.End]}
+	}
+	if err := writeJson(filepath.Join(dir, "tokens.json"), tokens); err != nil {
+		return err
+	}
+	ast, err := pg_query.ParseToJSON(text)
+	if err != nil {
+		return os.WriteFile(filepath.Join(dir, "err.txt"), []byte(fmt.Sprintf("%v", err)), rw)
+	}
+	var pretty map[string]interface{}
+	if err := json.Unmarshal([]byte(ast), &pretty); err != nil {
+		return err
+	}
+	return writeJson(filepath.Join(dir, "ast.json"), pretty)
+}
+
+// versionDir zero-pads the major version like scripts/link.sh, e.g. 013.
+func versionDir(version string) string {
+	major, rest := version, ""
+	if i := strings.Index(version, "."); i >= 0 {
+		major, rest = version[:i], version[i:]
+	}
+	if n, err := strconv.Atoi(major); err == nil {
+		return fmt.Sprintf("%03d%s", n, rest)
+	}
+	return version
+}
+
+// suiteNames names each document's directory after the file at its first url,
+// e.g. "create_table" for .../regress/sql/create_table.sql. Documents that
+// would share a name are told apart by their ids, except for the lowest id.
+func suiteNames(sources map[int64]*corpus.DocumentSource) map[int64]string {
+	ids := make([]int64, 0, len(sources))
+	for id := range sources {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	names := make(map[int64]string, len(ids))
+	taken := map[string]bool{}
+	for _, id := range ids {
+		name := ""
+		if urls := sources[id].Urls; len(urls) > 0 {
+			if parsed, err := url.Parse(urls[0]); err == nil {
+				name = path.Base(parsed.Path)
+				name = strings.TrimSuffix(name, path.Ext(name))
+			}
+		}
+		if name == "" || name == "." || name == "/" {
+			name = fmt.Sprintf("%x", uint64(id))
+		} else if taken[name] {
+			name = fmt.Sprintf("%s-%x", name, uint64(id))
+		}
+		taken[name] = true
+		names[id] = name
+	}
+	return names
+}
+
+type fixtureWriter struct {
+	out      string
+	language string
+	oracles  map[int64]*corpus.OracleInfo
+	sources  map[int64]*corpus.DocumentSource
+	suites   map[int64]string
+	parse    bool
+
+	written     int
+	unversioned int
+}
+
+// write lays out one fixture per version with verdicts about the statement.
+func (w *fixtureWriter) write(statement *corpus.DocumentStatement) error {
+	byVersion := map[string][]*fixtureVerdict{}
+	for _, prediction := range statement.Predictions {
+		oracle, ok := w.oracles[prediction.OracleId]
+		if !ok || oracle.Version() == "" {
+			continue
+		}
+		valid := prediction.Valid
+		if prediction.Outcome != "" {
+			valid = prediction.Outcome.Validity()
+		}
+		version := oracle.Version()
+		byVersion[version] = append(byVersion[version], &fixtureVerdict{
+			Oracle:   oracle.Name,
+			OracleId: fmt.Sprintf("%x", uint64(oracle.Id)),
+			Outcome:  prediction.Outcome,
+			Valid:    valid,
+			Error:    prediction.Error,
+		})
+	}
+	if len(byVersion) == 0 {
+		w.unversioned++
+		return nil
+	}
+	source, ok := w.sources[statement.DocumentId]
+	if !ok {
+		source = &corpus.DocumentSource{Urls: []string{}, Licenses: []string{}}
+	}
+	suite, ok := w.suites[statement.DocumentId]
+	if !ok {
+		suite = fmt.Sprintf("%x", uint64(statement.DocumentId))
+	}
+	for version, verdicts := range byVersion {
+		dir := filepath.Join(
+			w.out, "versions", versionDir(version), suite, fmt.Sprintf("%04d", statement.Ordinal),
+		)
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, "input.sql"), []byte(statement.Text), rw); err != nil {
+			return err
+		}
+		expected := fixtureExpectation{
+			StatementId: fmt.Sprintf("%x", uint64(statement.StatementId)),
+			Language:    w.language,
+			Version:     version,
+			DocumentId:  fmt.Sprintf("%x", uint64(statement.DocumentId)),
+			StartLine:   statement.StartLine,
+			EndLine:     statement.EndLine,
+			Verdicts:    verdicts,
+			Urls:        source.Urls,
+			Licenses:    source.Licenses,
+			Valid:       consensus(verdicts),
+		}
+		if err := writeJson(filepath.Join(dir, "expected.json"), expected); err != nil {
+			return err
+		}
+		if w.parse && version == pgQueryVersion {
+			if err := writeParse(dir, statement.Text); err != nil {
+				return err
+			}
+		}
+		w.written++
+	}
+	return nil
+}
+
+func consensus(verdicts []*fixtureVerdict) *bool {
+	var result *bool
+	for _, verdict := range verdicts {
+		if verdict.Valid == nil {
+			continue
+		}
+		if result != nil && *result != *verdict.Valid {
+			return nil
+		}
+		result = verdict.Valid
+	}
+	return result
+}
+
+var fixturesCmd = &cobra.Command{
+	Use:   "fixtures",
+	Short: "Write one parser fixture directory per statement and version",
+	Long: "Write one parser fixture directory per statement and version, laid out as\n" +
+		"  OUT/versions/<version>/<document>/<position>/\n" +
+		"where <document> is named after the file the statement came from and <position>\n" +
+		"is the statement's place in it, counting statements in every language. Positions\n" +
+		"skip statements in other languages and statements that the version's oracles\n" +
+		"have no verdicts about. Each directory holds\n" +
+		"  input.sql      the statement\n" +
+		"  expected.json  each oracle's verdict for the version, their consensus, and sources\n" +
+		"  tokens.json    pg_query's tokens and AST, like scripts/parse writes, for version " + pgQueryVersion + "\n" +
+		"  ast.json\n" +
+		"  err.txt        instead of ast.json if pg_query couldn't parse the statement\n" +
+		"Statements without predictions from oracles for a known version are skipped.\n" +
+		"OUT/versions is deleted first, so that statements no longer in the corpus don't\n" +
+		"linger as fixtures.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		out, err := flags.GetString("out")
+		if err != nil {
+			return err
+		}
+		parse, err := flags.GetBool("parse")
+		if err != nil {
+			return err
+		}
+		db, err := openCorpus(cmd)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		language, err := languageFlag(cmd, db)
+		if err != nil {
+			return err
+		}
+		ctx := cmd.Context()
+		oracles, err := corpus.GetOracles(ctx, db)
+		if err != nil {
+			return err
+		}
+		sources, err := corpus.GetDocumentSources(ctx, db)
+		if err != nil {
+			return err
+		}
+		// fixtures from an earlier run may be for statements since removed
+		if err := os.RemoveAll(filepath.Join(out, "versions")); err != nil {
+			return err
+		}
+		w := fixtureWriter{
+			out:      out,
+			language: language.Name,
+			oracles:  oracles,
+			sources:  sources,
+			suites:   suiteNames(sources),
+			parse:    parse && language.Id == languages.Pgsql,
+		}
+		if err := corpus.EachDocumentStatement(ctx, db, language.Id, w.write); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "wrote %d fixtures to %s\n", w.written, out)
+		if w.unversioned > 0 {
+			fmt.Fprintf(os.Stderr, "skipped %d statements without versioned predictions\n", w.unversioned)
+		}
+		return nil
+	},
+}
+
+func init() {
+	flags := fixturesCmd.Flags()
+	flags.String("corpus", "./corpus.db", "path to the sqlite corpus database")
+	flags.StringP("out", "o", "./fixtures", "the directory to write fixtures into")
+	flags.String("language", "pgsql", "which language's statements to write")
+	flags.Bool("parse", true, "write pg_query's tokens and AST for pgsql statements")
+	rootCmd.AddCommand(fixturesCmd)
+}
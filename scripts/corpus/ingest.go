@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+
+	"github.com/skalt/pg_sql_tests/pkg/corpus"
+	"github.com/skalt/pg_sql_tests/pkg/languages"
+	"github.com/skalt/pg_sql_tests/pkg/splitter"
+	"github.com/spf13/cobra"
+)
+
+var ingestCmd = &cobra.Command{
+	Use:   "ingest [FILE...]",
+	Short: "Split sql files into statements and add them to a corpus",
+	Long: "Split sql files into statements and add them to a corpus, creating the corpus\n" +
+		"if it doesn't exist yet. Reads stdin if no files are given.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		corpusPath, err := flags.GetString("corpus")
+		if err != nil {
+			return err
+		}
+		urls, err := flags.GetStringArray("url")
+		if err != nil {
+			return err
+		}
+		licensePath, err := flags.GetString("license")
+		if err != nil {
+			return err
+		}
+		spdx, err := flags.GetString("spdx")
+		if err != nil {
+			return err
+		}
+		count, err := flags.GetBool("count")
+		if err != nil {
+			return err
+		}
+		if licensePath != "" && spdx == "" {
+			return fmt.Errorf("missing the spdx identifier for %s", licensePath)
+		}
+		if len(args) > 1 && len(urls) > 0 {
+			return fmt.Errorf("--url can only describe one input file")
+		}
+		if len(args) == 0 {
+			args = []string{"-"}
+		}
+
+		db, err := corpus.ConnectOrCreate(corpusPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		// statements are pgsql or psql unless the language says otherwise
+		var language *corpus.Language
+		if language, err = optionalLanguageFlag(cmd, db); err != nil {
+			return err
+		}
+		ingester, err := corpus.NewIngester(cmd.Context(), db)
+		if err != nil {
+			return err
+		}
+		defer ingester.Rollback()
+		if licensePath != "" {
+			license, err := os.ReadFile(licensePath)
+			if err != nil {
+				return err
+			}
+			if err := ingester.AddLicense(spdx, string(license)); err != nil {
+				return err
+			}
+		}
+		urlIds := make([]int64, len(urls))
+		for i, url := range urls {
+			if urlIds[i], err = ingester.AddUrl(url, spdx); err != nil {
+				return err
+			}
+		}
+		for _, path := range args {
+			text, err := readInput(path)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			splits := splitStatements(text)
+			if language != nil {
+				for i := range splits {
+					splits[i].LanguageId = language.Id
+				}
+			}
+			n, err := ingester.IngestDocument(text, urlIds, splits)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if count {
+				unique := map[string]bool{}
+				for _, split := range splits {
+					unique[split.Text] = true
+				}
+				if n == 0 && len(splits) > 0 {
+					fmt.Printf("%s: already ingested\n", path)
+				} else {
+					fmt.Printf("%6d unique, %6d total statements\n", len(unique), n)
+				}
+			}
+		}
+		return ingester.Commit()
+	},
+}
+
+func readInput(path string) (string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(data) {
+		return "", fmt.Errorf("stream did not contain valid UTF-8")
+	}
+	return string(data), nil
+}
+
+// splitStatements splits a psql script and tags each statement as psql or pgsql.
+func splitStatements(script string) []corpus.Split {
+	statements := splitter.Split(script)
+	splits := make([]corpus.Split, len(statements))
+	for i, statement := range statements {
+		language := languages.Pgsql
+		if statement.Psql {
+			language = languages.Psql
+		}
+		splits[i] = corpus.Split{
+			Text:       statement.Text,
+			LanguageId: language,
+			Span: corpus.Span{
+				StartLine:   statement.StartLine,
+				EndLine:     statement.EndLine,
+				StartOffset: statement.Start,
+				EndOffset:   statement.End,
+			},
+		}
+	}
+	return splits
+}
+
+func init() {
+	flags := ingestCmd.Flags()
+	flags.String("corpus", "./corpus.db", "path to the sqlite corpus database")
+	flags.StringArray("url", nil, "a url at which the input may be found; may be repeated")
+	flags.String("license", "", "path to the license governing the urls")
+	flags.String("spdx", "", "spdx identifier of the license")
+	flags.String("language", "", "tag every statement with this language, as listed by corpus languages, rather than pgsql or psql")
+	flags.BoolP("count", "c", false, "print the number of statements in each file")
+	rootCmd.AddCommand(ingestCmd)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/skalt/pg_sql_tests/pkg/corpus"
+	"github.com/spf13/cobra"
+)
+
+// languageFlag looks up the language named by the --language flag in the
+// corpus.
+func languageFlag(cmd *cobra.Command, db *sql.DB) (*corpus.Language, error) {
+	name, err := cmd.Flags().GetString("language")
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, fmt.Errorf("--language: missing a language")
+	}
+	language, err := corpus.LookupLanguage(cmd.Context(), db, name)
+	if err != nil {
+		return nil, fmt.Errorf("--language: %w", err)
+	}
+	return language, nil
+}
+
+// optionalLanguageFlag is languageFlag, but returns nil if the flag is empty.
+func optionalLanguageFlag(cmd *cobra.Command, db *sql.DB) (*corpus.Language, error) {
+	if name, err := cmd.Flags().GetString("language"); err != nil || name == "" {
+		return nil, err
+	}
+	return languageFlag(cmd, db)
+}
+
+// languageNames maps the ids of the corpus' languages to their names.
+func languageNames(ctx context.Context, db *sql.DB) (map[int64]string, error) {
+	languages, err := corpus.GetLanguages(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int64]string, len(languages))
+	for _, language := range languages {
+		names[language.Id] = language.Name
+	}
+	return names, nil
+}
+
+// languageName falls back to the id of languages the corpus doesn't name.
+func languageName(names map[int64]string, id int64) string {
+	if name, ok := names[id]; ok {
+		return name
+	}
+	return fmt.Sprint(id)
+}
+
+type languageRow struct {
+	Id      int64  `json:"id"`
+	Name    string `json:"name"`
+	Family  string `json:"family,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+var languagesCmd = &cobra.Command{
+	Use:   "languages",
+	Short: "List the languages statements in the corpus can be written in",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := getFormat(cmd)
+		if err != nil {
+			return err
+		}
+		db, err := openCorpus(cmd)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		all, err := corpus.GetLanguages(cmd.Context(), db)
+		if err != nil {
+			return err
+		}
+		rows := make([]*languageRow, len(all))
+		for i, language := range all {
+			rows[i] = &languageRow{language.Id, language.Name, language.Family, language.Version}
+		}
+		if format == "json" {
+			return printJson(rows)
+		}
+		for _, row := range rows {
+			fmt.Printf("%20d %-12s %s %s\n", row.Id, row.Name, row.Family, row.Version)
+		}
+		return nil
+	},
+}
+
+var addLanguageCmd = &cobra.Command{
+	Use:   "add NAME",
+	Short: "Add a language, e.g. a new dialect, to the corpus",
+	Long: "Add a language to the corpus' languages table so that statements can be\n" +
+		"ingested, predicted, and exported in it. Its id is the xxhash of its name, so\n" +
+		"corpora that add the same language can still be merged.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		language := corpus.Language{Name: args[0]}
+		var err error
+		if language.Family, err = cmd.Flags().GetString("family"); err != nil {
+			return err
+		}
+		if language.Version, err = cmd.Flags().GetString("version"); err != nil {
+			return err
+		}
+		db, err := openCorpus(cmd)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if err := corpus.AddLanguage(cmd.Context(), db, &language); err != nil {
+			return err
+		}
+		fmt.Printf("added %s with id %d\n", language.Name, language.Id)
+		return nil
+	},
+}
+
+func init() {
+	languagesCmd.PersistentFlags().String("corpus", "./corpus.db", "path to the sqlite corpus database")
+	languagesCmd.Flags().String("format", "text", "text or json")
+	addLanguageCmd.Flags().String("family", "", "the family of versions the language is tested against, e.g. postgres")
+	addLanguageCmd.Flags().String("version", "", "the version of the family the language is pinned to, if any")
+	languagesCmd.AddCommand(addLanguageCmd)
+	rootCmd.AddCommand(languagesCmd)
+}
@@ -0,0 +1,31 @@
+// corpus manages corpus databases: upgrading, inspecting, and combining them.
+// Each subcommand lives in its own file.
+package main
+
+import (
+	"database/sql"
+	"os"
+
+	"github.com/skalt/pg_sql_tests/pkg/corpus"
+	"github.com/spf13/cobra"
+)
+
+var rootCmd = &cobra.Command{
+	Use:   "corpus",
+	Short: "Manage corpus databases",
+}
+
+// openCorpus connects to the existing corpus named by the --corpus flag.
+func openCorpus(cmd *cobra.Command) (*sql.DB, error) {
+	path, err := cmd.Flags().GetString("corpus")
+	if err != nil {
+		return nil, err
+	}
+	return corpus.ConnectToExisting(path)
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/skalt/pg_sql_tests/pkg/corpus"
+	"github.com/spf13/cobra"
+)
+
+type mergeVerdict struct {
+	Outcome corpus.Outcome `json:"outcome,omitempty"`
+	Valid   *bool          `json:"valid"`
+	Error   string         `json:"error,omitempty"`
+}
+
+type mergeConflict struct {
+	StatementId string        `json:"statement_id"`
+	OracleId    string        `json:"oracle_id"`
+	Language    string        `json:"language"`
+	Existing    *mergeVerdict `json:"existing"`
+	Incoming    *mergeVerdict `json:"incoming"`
+}
+
+type mergeCollision struct {
+	Table    string `json:"table"`
+	Key      string `json:"key"`
+	Existing string `json:"existing"`
+	Incoming string `json:"incoming"`
+}
+
+type mergeTable struct {
+	Table    string `json:"table"`
+	Inserted int64  `json:"inserted"`
+}
+
+type mergeReport struct {
+	Source     string            `json:"source"`
+	Inserted   []*mergeTable     `json:"inserted"`
+	Duplicates int64             `json:"duplicates"`
+	Conflicts  int64             `json:"conflicts"`
+	Examples   []*mergeConflict  `json:"examples"`
+	Collisions []*mergeCollision `json:"collisions"`
+	Error      string            `json:"error,omitempty"`
+}
+
+func toMergeVerdict(verdict *corpus.Verdict) *mergeVerdict {
+	return &mergeVerdict{verdict.Outcome, verdict.Valid, snippet(verdict.Error, 120)}
+}
+
+func toMergeReport(summary *corpus.MergeSummary, languages map[int64]string) *mergeReport {
+	report := mergeReport{
+		Source:     summary.Source,
+		Inserted:   []*mergeTable{},
+		Duplicates: summary.Duplicates,
+		Conflicts:  summary.Conflicts,
+		Examples:   []*mergeConflict{},
+		Collisions: []*mergeCollision{},
+	}
+	for _, c := range summary.Collisions {
+		report.Collisions = append(report.Collisions, &mergeCollision{c.Table, c.Key, c.Existing, c.Incoming})
+	}
+	for _, table := range summary.Tables {
+		report.Inserted = append(report.Inserted, &mergeTable{table, summary.Inserted[table]})
+	}
+	for _, conflict := range summary.ConflictExamples {
+		report.Examples = append(report.Examples, &mergeConflict{
+			StatementId: fmt.Sprintf("%x", uint64(conflict.Existing.StatementId)),
+			OracleId:    fmt.Sprintf("%x", uint64(conflict.Existing.OracleId)),
+			Language:    languageName(languages, conflict.Existing.LanguageId),
+			Existing:    toMergeVerdict(conflict.Existing),
+			Incoming:    toMergeVerdict(conflict.Incoming),
+		})
+	}
+	return &report
+}
+
+func printMergeReport(report *mergeReport) {
+	fmt.Printf("%s:\n", report.Source)
+	for _, collision := range report.Collisions {
+		fmt.Printf("  collision in %s at %s: %q vs %q\n",
+			collision.Table, collision.Key, snippet(collision.Existing, 60), snippet(collision.Incoming, 60))
+	}
+	for _, table := range report.Inserted {
+		if table.Inserted > 0 {
+			fmt.Printf("  %s: +%d\n", table.Table, table.Inserted)
+		}
+	}
+	fmt.Printf("  %d duplicate predictions, %d conflicting predictions\n", report.Duplicates, report.Conflicts)
+	verdict := func(v *mergeVerdict) string {
+		validity := "unknown"
+		if v.Valid != nil && *v.Valid {
+			validity = "valid"
+		} else if v.Valid != nil {
+			validity = "invalid"
+		}
+		result := fmt.Sprintf("%s [%s]", validity, v.Outcome)
+		if v.Error != "" {
+			result += ": " + v.Error
+		}
+		return result
+	}
+	for _, example := range report.Examples {
+		fmt.Printf("    %s by %s in %s: %s vs %s\n",
+			example.StatementId, example.OracleId, example.Language,
+			verdict(example.Existing), verdict(example.Incoming))
+	}
+	if report.Error != "" {
+		fmt.Printf("  error: %s\n", report.Error)
+	}
+}
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge --out=CORPUS INPUT...",
+	Short: "Merge corpus databases into a new corpus, detecting conflicts and hash collisions",
+	Long: "Merge corpus databases into a new corpus, one input at a time in the order given.\n" +
+		"Every input must be at the current schema version; see `corpus migrate`.\n" +
+		"Rows that share a key but differ, e.g. statements whose texts hash to the same\n" +
+		"id, stop the merge. Predictions with different verdicts on the same statement,\n" +
+		"oracle, and language are resolved by --policy:\n" +
+		"  keep-first   keep the prediction from the earlier input\n" +
+		"  keep-latest  keep the prediction from the later input\n" +
+		"  fail         stop the merge\n" +
+		"  record-both  keep the earlier prediction and the later one in prediction_conflicts\n" +
+		"If the merge stops, the output is removed.",
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		flags := cmd.Flags()
+		out, err := flags.GetString("out")
+		if err != nil {
+			return err
+		}
+		policyName, err := flags.GetString("policy")
+		if err != nil {
+			return err
+		}
+		policy, err := corpus.ParseMergePolicy(policyName)
+		if err != nil {
+			return fmt.Errorf("--policy: %w", err)
+		}
+		format, err := getFormat(cmd)
+		if err != nil {
+			return err
+		}
+		examples, err := flags.GetInt("examples")
+		if err != nil {
+			return err
+		}
+		db, err := corpus.Create(out)
+		if err != nil {
+			return fmt.Errorf("--out: %w", err)
+		}
+		defer func() {
+			db.Close()
+			if err != nil {
+				os.Remove(out)
+			}
+		}()
+
+		reports := []*mergeReport{}
+		defer func() {
+			if format == "json" {
+				printJson(reports)
+			}
+		}()
+		for _, path := range args {
+			summary, mergeErr := corpus.Merge(cmd.Context(), db, path, policy, examples)
+			if summary != nil {
+				languages, err := languageNames(cmd.Context(), db)
+				if err != nil {
+					return err
+				}
+				report := toMergeReport(summary, languages)
+				if mergeErr != nil {
+					report.Error = mergeErr.Error()
+				}
+				reports = append(reports, report)
+				if format == "text" {
+					printMergeReport(report)
+				}
+			}
+			if mergeErr != nil {
+				if errors.Is(mergeErr, corpus.ErrCollision) || errors.Is(mergeErr, corpus.ErrConflict) {
+					cmd.SilenceUsage = true
+				}
+				return fmt.Errorf("%s: %w", path, mergeErr)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	flags := mergeCmd.Flags()
+	flags.String("out", "./corpus.db", "a path for the merged corpus; it must not exist yet")
+	flags.String("policy", string(corpus.KeepFirst), "how to resolve conflicting predictions: keep-first, keep-latest, fail, or record-both")
+	flags.String("format", "text", "text or json")
+	flags.Int("examples", 3, "how many conflicting predictions to show per input; 0 shows them all")
+	rootCmd.AddCommand(mergeCmd)
+}
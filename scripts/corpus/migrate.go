@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/skalt/pg_sql_tests/pkg/corpus"
+	"github.com/spf13/cobra"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate CORPUS...",
+	Short: "Upgrade corpus databases in place to the current schema version",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			return err
+		}
+		for _, path := range args {
+			if err := migrate(cmd, path, dryRun); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+		}
+		return nil
+	},
+}
+
+func migrate(cmd *cobra.Command, path string, dryRun bool) error {
+	db, err := corpus.OpenExisting(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	major, minor, err := corpus.SchemaVersion(cmd.Context(), db)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		migrations, err := corpus.Migrations()
+		if err != nil {
+			return err
+		}
+		pending := 0
+		for _, migration := range migrations {
+			if migration.Major == major && migration.Minor > minor {
+				fmt.Printf("%s: would migrate %d.%d -> %s\n", path, major, minor, migration)
+				major, minor = migration.Major, migration.Minor
+				pending++
+			}
+		}
+		if pending == 0 {
+			fmt.Printf("%s: up to date at %d.%d\n", path, major, minor)
+		}
+		return nil
+	}
+	applied, err := corpus.Migrate(cmd.Context(), db)
+	for _, migration := range applied {
+		fmt.Printf("%s: migrated %d.%d -> %s\n", path, major, minor, migration)
+		major, minor = migration.Major, migration.Minor
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Printf("%s: up to date at %d.%d\n", path, major, minor)
+	}
+	return nil
+}
+
+func init() {
+	migrateCmd.Flags().Bool("dry-run", false, "print the migrations that would run without running them")
+	rootCmd.AddCommand(migrateCmd)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/skalt/pg_sql_tests/pkg/corpus"
+	"github.com/spf13/cobra"
+)
+
+var nodesCmd = &cobra.Command{
+	Use:   "nodes",
+	Short: "Index which parse tree nodes and options each pgsql statement uses",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := openCorpus(cmd)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		indexed, unparseable, err := corpus.BackfillNodes(cmd.Context(), db)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("indexed %d statements; pg_query couldn't parse %d\n", indexed, unparseable)
+		return nil
+	},
+}
+
+// addConstructFlags lets a command and its subcommands filter statements by
+// what `corpus nodes` indexed in them.
+func addConstructFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringArray(
+		"kind", nil,
+		"only include statements whose top-level statement is this node type, e.g. AlterTableStmt; may be repeated",
+	)
+	cmd.PersistentFlags().StringArray(
+		"construct", nil,
+		"only include statements using this node type or option, e.g. WindowDef or RangeSubselect.lateral;\n"+
+			"may be repeated to require several, as indexed by corpus nodes",
+	)
+}
+
+func getConstructFilter(cmd *cobra.Command) (filter corpus.ConstructFilter, err error) {
+	if filter.Kinds, err = cmd.Flags().GetStringArray("kind"); err != nil {
+		return filter, err
+	}
+	filter.Constructs, err = cmd.Flags().GetStringArray("construct")
+	return filter, err
+}
+
+func init() {
+	nodesCmd.Flags().String("corpus", "./corpus.db", "path to the sqlite corpus database")
+	rootCmd.AddCommand(nodesCmd)
+}
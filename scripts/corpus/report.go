@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Summarize what the oracles predicted",
+}
+
+// snippet shortens text to its first line and at most n runes.
+func snippet(text string, n int) string {
+	text = strings.TrimSpace(text)
+	if end := strings.IndexByte(text, '\n'); end >= 0 {
+		text = text[:end] + " …"
+	}
+	if runes := []rune(text); len(runes) > n {
+		text = string(runes[:n-1]) + "…"
+	}
+	return text
+}
+
+func printJson(value interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func getFormat(cmd *cobra.Command) (string, error) {
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return "", err
+	}
+	if format != "text" && format != "json" {
+		return "", fmt.Errorf("--format: expected text or json, got %s", format)
+	}
+	return format, nil
+}
+
+func init() {
+	reportCmd.PersistentFlags().String("corpus", "./corpus.db", "path to the sqlite corpus database")
+	reportCmd.PersistentFlags().String("format", "text", "text or json")
+	addConstructFlags(reportCmd)
+	rootCmd.AddCommand(reportCmd)
+}
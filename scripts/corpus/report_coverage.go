@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/skalt/pg_sql_tests/pkg/corpus"
+	"github.com/spf13/cobra"
+)
+
+// a constructCoverage compares the node types or keywords a version accepts in
+// the corpus against every one pg_query knows.
+type constructCoverage struct {
+	Kind    string  `json:"kind"`
+	Total   int     `json:"total"`
+	Covered int     `json:"covered"`
+	Percent float64 `json:"percent"`
+	// used only by statements the version rejects
+	OnlyInvalid []string `json:"only_invalid"`
+	// not used by any statement the version's oracles graded
+	Uncovered []string `json:"uncovered"`
+}
+
+type versionCoverage struct {
+	Version    string               `json:"version"`
+	Constructs []*constructCoverage `json:"constructs"`
+}
+
+type coverageReport struct {
+	Language string             `json:"language"`
+	Versions []*versionCoverage `json:"versions"`
+}
+
+func summarizeCoverage(language string, uses []*corpus.ConstructUse) *coverageReport {
+	grammar := map[string][]string{
+		corpus.ConstructNode:    corpus.GrammarNodeTypes(),
+		corpus.ConstructKeyword: corpus.GrammarKeywords(),
+	}
+	// version -> kind -> construct -> accepted
+	seen := map[string]map[string]map[string]bool{}
+	for _, use := range uses {
+		if seen[use.Version] == nil {
+			seen[use.Version] = map[string]map[string]bool{}
+		}
+		if seen[use.Version][use.Kind] == nil {
+			seen[use.Version][use.Kind] = map[string]bool{}
+		}
+		seen[use.Version][use.Kind][use.Construct] = use.Accepted
+	}
+	report := coverageReport{Language: language, Versions: []*versionCoverage{}}
+	for version, byKind := range seen {
+		coverage := versionCoverage{Version: version}
+		for _, kind := range []string{corpus.ConstructNode, corpus.ConstructKeyword} {
+			counts := constructCoverage{
+				Kind:        kind,
+				Total:       len(grammar[kind]),
+				OnlyInvalid: []string{},
+				Uncovered:   []string{},
+			}
+			for _, construct := range grammar[kind] {
+				accepted, ok := byKind[kind][construct]
+				switch {
+				case !ok:
+					counts.Uncovered = append(counts.Uncovered, construct)
+				case !accepted:
+					counts.OnlyInvalid = append(counts.OnlyInvalid, construct)
+				default:
+					counts.Covered++
+				}
+			}
+			if counts.Total > 0 {
+				counts.Percent = 100 * float64(counts.Covered) / float64(counts.Total)
+			}
+			coverage.Constructs = append(coverage.Constructs, &counts)
+		}
+		report.Versions = append(report.Versions, &coverage)
+	}
+	sort.Slice(report.Versions, func(i, j int) bool {
+		return corpus.CompareVersions(report.Versions[i].Version, report.Versions[j].Version) < 0
+	})
+	return &report
+}
+
+func printCoverage(report *coverageReport, list bool) {
+	if len(report.Versions) == 0 {
+		fmt.Printf(
+			"no graded %s statements have been indexed; try `corpus tokens` and `corpus nodes`\n",
+			report.Language,
+		)
+	}
+	for _, version := range report.Versions {
+		fmt.Printf("%s @ %s\n", report.Language, version.Version)
+		for _, counts := range version.Constructs {
+			fmt.Printf(
+				"  %ss: %d/%d covered (%.1f%%), %d only by invalid statements, %d uncovered\n",
+				counts.Kind, counts.Covered, counts.Total, counts.Percent,
+				len(counts.OnlyInvalid), len(counts.Uncovered),
+			)
+			if !list {
+				continue
+			}
+			if len(counts.OnlyInvalid) > 0 {
+				fmt.Printf("    only invalid: %s\n", strings.Join(counts.OnlyInvalid, " "))
+			}
+			if len(counts.Uncovered) > 0 {
+				fmt.Printf("    uncovered: %s\n", strings.Join(counts.Uncovered, " "))
+			}
+		}
+	}
+}
+
+var coverageCmd = &cobra.Command{
+	Use:   "coverage",
+	Short: "Compare the node types and keywords each version accepts against pg_query's grammar",
+	Long: "Compare the node types and keywords in the statements each version's oracles\n" +
+		"graded against every node type in pg_query's protobuf definitions and every\n" +
+		"keyword in its keyword list. A construct is covered if the version accepts a\n" +
+		"statement using it. Run `corpus tokens` and `corpus nodes` first to index the\n" +
+		"statements.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := getFormat(cmd)
+		if err != nil {
+			return err
+		}
+		list, err := cmd.Flags().GetBool("list")
+		if err != nil {
+			return err
+		}
+		constructs, err := getConstructFilter(cmd)
+		if err != nil {
+			return err
+		}
+		db, err := openCorpus(cmd)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		language, err := languageFlag(cmd, db)
+		if err != nil {
+			return err
+		}
+		uses, err := corpus.GetConstructCoverage(cmd.Context(), db, language.Id, constructs)
+		if err != nil {
+			return err
+		}
+		report := summarizeCoverage(language.Name, uses)
+		if format == "json" {
+			return printJson(report)
+		}
+		printCoverage(report, list)
+		return nil
+	},
+}
+
+func init() {
+	coverageCmd.Flags().String("language", "pgsql", "which language's statements to measure")
+	coverageCmd.Flags().Bool("list", true, "list the constructs that are uncovered or only used by invalid statements")
+	reportCmd.AddCommand(coverageCmd)
+}
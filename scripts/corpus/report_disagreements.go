@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/skalt/pg_sql_tests/pkg/corpus"
+	"github.com/spf13/cobra"
+)
+
+type verdict struct {
+	Oracle  string         `json:"oracle"`
+	Valid   bool           `json:"valid"`
+	Outcome corpus.Outcome `json:"outcome,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+type disagreement struct {
+	StatementId string     `json:"statement_id"`
+	Text        string     `json:"text"`
+	Urls        []string   `json:"urls,omitempty"`
+	Verdicts    [2]verdict `json:"verdicts"`
+}
+
+type kindGroup struct {
+	Kind     string          `json:"kind"`
+	Count    int             `json:"count"`
+	Examples []*disagreement `json:"examples"`
+}
+
+// a pairGroup collects the disagreements between two oracles for the same
+// language and version.
+type pairGroup struct {
+	Language string       `json:"language"`
+	Version  string       `json:"version"`
+	Oracles  [2]string    `json:"oracles"`
+	Count    int          `json:"count"`
+	Kinds    []*kindGroup `json:"kinds"`
+	kinds    map[string]*kindGroup
+}
+
+type disagreementReport struct {
+	groups    map[string]*pairGroup
+	oracles   map[int64]*corpus.OracleInfo
+	languages map[int64]string
+	examples  int // per kind; 0 keeps them all
+}
+
+// add compares every pair of predictions on the same statement and language
+// made by oracles for the same version.
+func (report *disagreementReport) add(predictions []*corpus.ContestedPrediction) {
+	kind := ""
+	for i, a := range predictions {
+		for _, b := range predictions[i+1:] {
+			oracleA, oracleB := report.oracles[a.OracleId], report.oracles[b.OracleId]
+			if a.Valid == b.Valid || oracleA == nil || oracleB == nil || oracleA.Version() != oracleB.Version() {
+				continue
+			}
+			if oracleB.Name < oracleA.Name {
+				a, b = b, a
+				oracleA, oracleB = oracleB, oracleA
+			}
+			if kind == "" {
+				kind = corpus.StatementKind(a.Text)
+			}
+			language := languageName(report.languages, a.LanguageId)
+			key := fmt.Sprintf("%s\x00%s\x00%s\x00%s", language, oracleA.Version(), oracleA.Name, oracleB.Name)
+			group, ok := report.groups[key]
+			if !ok {
+				group = &pairGroup{
+					Language: language,
+					Version:  oracleA.Version(),
+					Oracles:  [2]string{oracleA.Name, oracleB.Name},
+					kinds:    map[string]*kindGroup{},
+				}
+				report.groups[key] = group
+			}
+			group.Count++
+			byKind, ok := group.kinds[kind]
+			if !ok {
+				byKind = &kindGroup{Kind: kind, Examples: []*disagreement{}}
+				group.kinds[kind] = byKind
+			}
+			byKind.Count++
+			if report.examples == 0 || len(byKind.Examples) < report.examples {
+				byKind.Examples = append(byKind.Examples, &disagreement{
+					StatementId: fmt.Sprintf("%x", uint64(a.StatementId)),
+					Text:        a.Text,
+					Urls:        a.Urls,
+					Verdicts: [2]verdict{
+						{oracleA.Name, a.Valid, a.Outcome, snippet(a.Error, 120)},
+						{oracleB.Name, b.Valid, b.Outcome, snippet(b.Error, 120)},
+					},
+				})
+			}
+		}
+	}
+}
+
+// sorted lists the groups, and each group's kinds, by descending count.
+func (report *disagreementReport) sorted() []*pairGroup {
+	groups := make([]*pairGroup, 0, len(report.groups))
+	for _, group := range report.groups {
+		group.Kinds = make([]*kindGroup, 0, len(group.kinds))
+		for _, byKind := range group.kinds {
+			group.Kinds = append(group.Kinds, byKind)
+		}
+		sort.Slice(group.Kinds, func(i, j int) bool {
+			if group.Kinds[i].Count != group.Kinds[j].Count {
+				return group.Kinds[i].Count > group.Kinds[j].Count
+			}
+			return group.Kinds[i].Kind < group.Kinds[j].Kind
+		})
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return fmt.Sprint(groups[i].Oracles) < fmt.Sprint(groups[j].Oracles)
+	})
+	return groups
+}
+
+func printDisagreements(groups []*pairGroup) {
+	if len(groups) == 0 {
+		fmt.Println("no disagreements found")
+	}
+	for _, group := range groups {
+		fmt.Printf(
+			"%s vs %s (%s @ %s): %d disagreements\n",
+			group.Oracles[0], group.Oracles[1], group.Language, group.Version, group.Count,
+		)
+		for _, byKind := range group.Kinds {
+			fmt.Printf("  %s: %d\n", byKind.Kind, byKind.Count)
+			for _, example := range byKind.Examples {
+				fmt.Printf("    %s %s\n", example.StatementId, snippet(example.Text, 100))
+				for _, url := range example.Urls {
+					fmt.Printf("      %s\n", url)
+				}
+				for _, v := range example.Verdicts {
+					if v.Error != "" {
+						fmt.Printf("      %s: %s: %s\n", v.Oracle, v.Outcome, v.Error)
+					} else {
+						fmt.Printf("      %s: %s\n", v.Oracle, v.Outcome)
+					}
+				}
+			}
+		}
+	}
+}
+
+var disagreementsCmd = &cobra.Command{
+	Use:   "disagreements",
+	Short: "List statements that oracles for the same language and version disagree about",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := getFormat(cmd)
+		if err != nil {
+			return err
+		}
+		examples, err := cmd.Flags().GetInt("examples")
+		if err != nil {
+			return err
+		}
+		constructs, err := getConstructFilter(cmd)
+		if err != nil {
+			return err
+		}
+		db, err := openCorpus(cmd)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		var languageId *int64
+		if language, err := optionalLanguageFlag(cmd, db); err != nil {
+			return err
+		} else if language != nil {
+			languageId = &language.Id
+		}
+		oracles, err := corpus.GetOracles(cmd.Context(), db)
+		if err != nil {
+			return err
+		}
+		languages, err := languageNames(cmd.Context(), db)
+		if err != nil {
+			return err
+		}
+		report := disagreementReport{
+			groups:    map[string]*pairGroup{},
+			oracles:   oracles,
+			languages: languages,
+			examples:  examples,
+		}
+		var batch []*corpus.ContestedPrediction
+		err = corpus.EachContestedPrediction(cmd.Context(), db, languageId, constructs, func(prediction *corpus.ContestedPrediction) error {
+			if len(batch) > 0 &&
+				(batch[0].StatementId != prediction.StatementId || batch[0].LanguageId != prediction.LanguageId) {
+				report.add(batch)
+				batch = batch[:0]
+			}
+			batch = append(batch, prediction)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		report.add(batch)
+		groups := report.sorted()
+		if format == "json" {
+			return printJson(groups)
+		}
+		printDisagreements(groups)
+		return nil
+	},
+}
+
+func init() {
+	disagreementsCmd.Flags().String("language", "", "only compare predictions for this language")
+	disagreementsCmd.Flags().Int("examples", 3, "how many statements to show per oracle pair and kind; 0 shows them all")
+	reportCmd.AddCommand(disagreementsCmd)
+}
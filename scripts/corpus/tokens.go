@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/skalt/pg_sql_tests/pkg/corpus"
+	"github.com/spf13/cobra"
+)
+
+var tokensCmd = &cobra.Command{
+	Use:   "tokens",
+	Short: "Fill statement_tokens with the tokens pg_query finds in each pgsql statement",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := openCorpus(cmd)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		statements, tokens, unscannable, err := corpus.BackfillTokens(cmd.Context(), db)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("recorded %d tokens in %d statements; pg_query couldn't scan %d\n", tokens, statements, unscannable)
+		return nil
+	},
+}
+
+func init() {
+	tokensCmd.Flags().String("corpus", "./corpus.db", "path to the sqlite corpus database")
+	rootCmd.AddCommand(tokensCmd)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/skalt/pg_sql_tests/pkg/corpus"
+	"github.com/spf13/cobra"
+)
+
+type versionExample struct {
+	StatementId string `json:"statement_id"`
+	Text        string `json:"text"`
+	Earliest    string `json:"earliest"`
+	Latest      string `json:"latest"`
+}
+
+type versionKindGroup struct {
+	Kind     string            `json:"kind"`
+	Count    int               `json:"count"`
+	Examples []*versionExample `json:"examples"`
+}
+
+type versionReport struct {
+	Language string           `json:"language"`
+	Versions []string         `json:"versions"`
+	Changes  []*versionChange `json:"changes"`
+}
+
+// a versionChange groups the statements introduced or removed in a version.
+type versionChange struct {
+	Change  string              `json:"change"` // "introduced" or "removed"
+	Version string              `json:"version"`
+	Count   int                 `json:"count"`
+	Kinds   []*versionKindGroup `json:"kinds"`
+}
+
+func summarizeVersionChanges(
+	language string,
+	versions []string,
+	changes []*corpus.VersionChange,
+	examples int,
+) *versionReport {
+	type key struct{ change, version string }
+	groups := map[key]*versionChange{}
+	kinds := map[key]map[string]*versionKindGroup{}
+	add := func(change string, version string, statement *corpus.VersionChange, kind string) {
+		k := key{change, version}
+		group, ok := groups[k]
+		if !ok {
+			group = &versionChange{Change: change, Version: version}
+			groups[k] = group
+			kinds[k] = map[string]*versionKindGroup{}
+		}
+		group.Count++
+		byKind, ok := kinds[k][kind]
+		if !ok {
+			byKind = &versionKindGroup{Kind: kind, Examples: []*versionExample{}}
+			kinds[k][kind] = byKind
+			group.Kinds = append(group.Kinds, byKind)
+		}
+		byKind.Count++
+		if examples == 0 || len(byKind.Examples) < examples {
+			byKind.Examples = append(byKind.Examples, &versionExample{
+				StatementId: fmt.Sprintf("%x", uint64(statement.StatementId)),
+				Text:        statement.Text,
+				Earliest:    statement.Earliest,
+				Latest:      statement.Latest,
+			})
+		}
+	}
+	for _, statement := range changes {
+		kind := corpus.StatementKind(statement.Text)
+		if statement.Introduced != "" {
+			add("introduced", statement.Introduced, statement, kind)
+		}
+		if statement.Removed != "" {
+			add("removed", statement.Removed, statement, kind)
+		}
+	}
+	report := versionReport{Language: language, Versions: versions, Changes: []*versionChange{}}
+	for _, group := range groups {
+		sort.Slice(group.Kinds, func(i, j int) bool {
+			if group.Kinds[i].Count != group.Kinds[j].Count {
+				return group.Kinds[i].Count > group.Kinds[j].Count
+			}
+			return group.Kinds[i].Kind < group.Kinds[j].Kind
+		})
+		report.Changes = append(report.Changes, group)
+	}
+	sort.Slice(report.Changes, func(i, j int) bool {
+		a, b := report.Changes[i], report.Changes[j]
+		if c := corpus.CompareVersions(a.Version, b.Version); c != 0 {
+			return c < 0
+		}
+		return a.Change < b.Change
+	})
+	return &report
+}
+
+func printVersionReport(report *versionReport) {
+	fmt.Printf("%s across versions %v\n", report.Language, report.Versions)
+	if len(report.Changes) == 0 {
+		fmt.Println("no statements change between versions")
+	}
+	for _, change := range report.Changes {
+		fmt.Printf("%s in %s: %d statements\n", change.Change, change.Version, change.Count)
+		for _, byKind := range change.Kinds {
+			fmt.Printf("  %s: %d\n", byKind.Kind, byKind.Count)
+			for _, example := range byKind.Examples {
+				fmt.Printf(
+					"    %s [%s..%s] %s\n",
+					example.StatementId, example.Earliest, example.Latest, snippet(example.Text, 100),
+				)
+			}
+		}
+	}
+}
+
+var versionsCmd = &cobra.Command{
+	Use:   "versions",
+	Short: "Derive which versions accept each statement and report syntax introduced or removed in each version",
+	Long: "Derive which versions accept each statement from the predictions of each version's\n" +
+		"oracles, filling the versions, language_versions, and statement_versions tables.\n" +
+		"A version accepts a statement if all of its oracles' graded predictions say the\n" +
+		"statement is valid.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := getFormat(cmd)
+		if err != nil {
+			return err
+		}
+		examples, err := cmd.Flags().GetInt("examples")
+		if err != nil {
+			return err
+		}
+		constructs, err := getConstructFilter(cmd)
+		if err != nil {
+			return err
+		}
+		db, err := openCorpus(cmd)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		language, err := languageFlag(cmd, db)
+		if err != nil {
+			return err
+		}
+		versions, changes, err := corpus.DeriveStatementVersions(
+			cmd.Context(), db, corpus.PostgresFamily, language.Id, constructs,
+		)
+		if err != nil {
+			return err
+		}
+		report := summarizeVersionChanges(language.Name, versions, changes, examples)
+		if format == "json" {
+			return printJson(report)
+		}
+		printVersionReport(report)
+		return nil
+	},
+}
+
+func init() {
+	flags := versionsCmd.Flags()
+	flags.String("corpus", "./corpus.db", "path to the sqlite corpus database")
+	flags.String("format", "text", "text or json")
+	flags.String("language", "pgsql", "which language's statements to compare")
+	flags.Int("examples", 3, "how many statements to show per version and kind; 0 shows them all")
+	addConstructFlags(versionsCmd)
+	rootCmd.AddCommand(versionsCmd)
+}
@@ -9,7 +9,10 @@
 ###              [default ./corpus.db]
 ### ARGS:
 ###   INPUT_DBS: paths to the input databases.  Must all exist and have
-###              schema_version 0.0
+###              schema_version 0.8
+###
+### Conflicting predictions are silently dropped; prefer `bin/corpus merge`,
+### which detects them along with hash collisions.
 
 usage() { grep -e "^###" "$0" |  sed 's/^### //g' | sed 's/###//g'; }
 get_absolute_path() { (cd "$(dirname "$1")" && pwd); }
@@ -18,7 +21,7 @@ get_db_schema_version() {
 }
 
 validate_input_db_version() {
-    get_db_schema_version "$1" | grep -q "0|0"
+    get_db_schema_version "$1" | grep -q "0|8"
 }
 bulk_sql="
 insert or ignore into main.languages              select * from other.languages;
@@ -27,13 +30,27 @@ insert or ignore into main.language_versions      select * from other.language_v
 insert or ignore into main.statements             select * from other.statements;
 insert or ignore into main.statement_languages    select * from other.statement_languages;
 insert or ignore into main.statement_fingerprints select * from other.statement_fingerprints;
+insert or ignore into main.statement_tokens       select * from other.statement_tokens;
+insert or ignore into main.statement_nodes        select * from other.statement_nodes;
+insert or ignore into main.statement_options      select * from other.statement_options;
 insert or ignore into main.statement_versions     select * from other.statement_versions;
 insert or ignore into main.documents              select * from other.documents;
 insert or ignore into main.urls                   select * from other.urls;
 insert or ignore into main.document_statements    select * from other.document_statements;
 insert or ignore into main.licenses               select * from other.licenses;
 insert or ignore into main.oracles                select * from other.oracles;
-insert or ignore into main.predictions            select * from other.predictions;
+insert or ignore into main.oracle_metadata        select * from other.oracle_metadata;
+insert or ignore into main.derived_statements     select * from other.derived_statements;
+insert or ignore into main.predictions (
+  statement_id, oracle_id, language_id, error, message, valid, outcome, inferred_from
+) select
+  statement_id, oracle_id, language_id, error, message, valid, outcome, inferred_from
+from other.predictions;
+insert into main.prediction_conflicts (
+  statement_id, oracle_id, language_id, error, message, valid, outcome, inferred_from, source
+) select
+  statement_id, oracle_id, language_id, error, message, valid, outcome, inferred_from, source
+from other.prediction_conflicts;
 "
 
 main() {